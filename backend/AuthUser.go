@@ -1,117 +1,198 @@
 package colorboxd
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"net/http"
 	"net/url"
 	"os"
-	"strings"
+	"time"
+
+	"github.com/dsantos747/letterboxd_hue_sort/backend/letterboxd"
 )
 
 // AuthUser handles the autorisation of the users letterboxd account to the colorboxd app.
-func AuthUser(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) AuthUser(w http.ResponseWriter, r *http.Request) {
 	var err error
 
 	// Read env variables
 	err = LoadEnv()
 	if err != nil {
-		fmt.Printf("Could not load environment variables from .env file: %v\n", err)
+		ReturnError(w, newAPIError(http.StatusInternalServerError, ErrCodeInternal, "could not load server configuration", err))
 		return
 	}
 
 	// Set necessary headers for CORS and cache policy
 	w.Header().Set("Access-Control-Allow-Origin", os.Getenv("BASE_URL"))
 	w.Header().Set("Access-Control-Allow-Credentials", "true")
-	w.Header().Set("Cache-Control", "private, max-age=3595") // Expire time of token (-5s for safety)
+	w.Header().Set("Cache-Control", "no-store") // Response sets the session cookie, so must never be cached
 
 	// Read authCode from query url - return error if not present
 	authCode := r.URL.Query().Get("authCode")
 	if authCode == "" {
-		ReturnError(w, "Missing or empty 'authCode' query parameter", http.StatusBadRequest)
+		ReturnError(w, badRequest("Missing or empty 'authCode' query parameter"))
 		return
 	}
 
 	// Get Access Token
-	accessTokenResponse, err := getAccessToken(authCode)
+	accessTokenResponse, err := getAccessToken(r.Context(), authCode)
 	if err != nil {
-		ReturnError(w, fmt.Errorf("could not create valid access token: %w", err).Error(), http.StatusInternalServerError)
+		ReturnError(w, apiErrorOr(err, http.StatusBadGateway, ErrCodeLetterboxdError, "could not create valid access token"))
 		return
 	}
 	if accessTokenResponse.AccessToken == "" {
-		ReturnError(w, fmt.Errorf("could not generate access token from provided auth code: %w", err).Error(), http.StatusBadRequest)
+		ReturnError(w, newAPIError(http.StatusUnauthorized, ErrCodeLetterboxdUnauthorized, "could not generate access token from provided auth code", nil))
 		return
 	}
 
-	member, err := getMemberId(accessTokenResponse.AccessToken)
+	member, err := getMemberId(r.Context(), newLetterboxdClient(accessTokenResponse.AccessToken))
 	if err != nil {
-		ReturnError(w, fmt.Errorf("could not retrieve member ID: %w", err).Error(), http.StatusInternalServerError)
+		ReturnError(w, apiErrorOr(err, http.StatusBadGateway, ErrCodeLetterboxdError, "could not retrieve member ID"))
 		return
 	}
 
-	response := AuthUserResponse{
-		Token:          accessTokenResponse.AccessToken,
-		TokenType:      accessTokenResponse.TokenType,
-		TokenRefresh:   accessTokenResponse.RefreshToken,
-		TokenExpiresIn: accessTokenResponse.ExpiresIn,
-		UserId:         member.ID,
-		Username:       member.Username,
-		UserGivenName:  member.GivenName,
+	// Store the tokens server-side; the client only receives an opaque session cookie
+	session, err := createSession(w, h.sessions, accessTokenResponse, member)
+	if err != nil {
+		ReturnError(w, newAPIError(http.StatusInternalServerError, ErrCodeInternal, "could not create session", err))
+		return
 	}
 
 	// Return response to client
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(newAuthUserResponse(session))
 }
 
-func getAccessToken(authCode string) (*AccessTokenResponse, error) {
-	// Prepare endpoint and body for POST request
-	method := "POST"
-	endpoint := fmt.Sprintf("%s/auth/token", os.Getenv("LBOXD_BASEURL"))
-	formData := url.Values{
-		"grant_type":    {"authorization_code"},
-		"code":          {authCode},
-		"redirect_uri":  {os.Getenv("LBOXD_REDIRECT_URL")},
-		"client_id":     {os.Getenv("LBOXD_KEY")},
-		"client_secret": {os.Getenv("LBOXD_SECRET")},
+// RefreshAuth exchanges the session's refresh token for a fresh access token, so the
+// user doesn't need to re-authorise once their hour-long access token expires.
+func (h *Handlers) RefreshAuth(w http.ResponseWriter, r *http.Request) {
+	var err error
+
+	// Read env variables
+	err = LoadEnv()
+	if err != nil {
+		ReturnError(w, newAPIError(http.StatusInternalServerError, ErrCodeInternal, "could not load server configuration", err))
+		return
+	}
+
+	// Set necessary headers for CORS and cache policy
+	w.Header().Set("Access-Control-Allow-Origin", os.Getenv("BASE_URL"))
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if err := checkRequestOrigin(r); err != nil {
+		ReturnError(w, err)
+		return
 	}
-	headers := map[string]string{"Content-Type": "application/x-www-form-urlencoded", "Accept": "application/json"}
 
-	response, err := MakeHTTPRequest(method, endpoint, strings.NewReader(formData.Encode()), headers)
+	id, session, err := getSession(r, h.sessions)
 	if err != nil {
-		return nil, err
+		returnSessionError(w, err)
+		return
 	}
-	defer response.Body.Close()
 
-	// Decode data into struct - handle error cases
-	var responseData AccessTokenResponse
-	err = json.NewDecoder(response.Body).Decode(&responseData)
+	err = refreshSession(r.Context(), h.sessions, id, session)
 	if err != nil {
-		return nil, err
+		ReturnError(w, apiErrorOr(err, http.StatusUnauthorized, ErrCodeLetterboxdUnauthorized, "could not refresh access token"))
+		return
 	}
 
-	return &responseData, nil
+	// Return response to client
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newAuthUserResponse(session))
 }
 
-func getMemberId(token string) (*Member, error) {
-	method := "GET"
-	endpoint := fmt.Sprintf("%s/me", os.Getenv("LBOXD_BASEURL"))
-	headers := map[string]string{"Authorization": fmt.Sprintf("Bearer %s", token)} // Is this actually necessary?
+// SignOut ends the user's session, deleting it server-side and expiring the session cookie.
+func (h *Handlers) SignOut(w http.ResponseWriter, r *http.Request) {
+	var err error
 
-	response, err := MakeHTTPRequest(method, endpoint, nil, headers)
+	// Read env variables
+	err = LoadEnv()
 	if err != nil {
-		return nil, err
+		ReturnError(w, newAPIError(http.StatusInternalServerError, ErrCodeInternal, "could not load server configuration", err))
+		return
 	}
-	defer response.Body.Close()
 
-	var responseData map[string]json.RawMessage
-	if err = json.NewDecoder(response.Body).Decode(&responseData); err != nil {
-		return nil, err
+	// Set necessary headers for CORS and cache policy
+	w.Header().Set("Access-Control-Allow-Origin", os.Getenv("BASE_URL"))
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
 	}
-	var member Member
-	if err = json.Unmarshal(responseData["member"], &member); err != nil {
-		return nil, err
+
+	if err := checkRequestOrigin(r); err != nil {
+		ReturnError(w, err)
+		return
 	}
 
-	return &member, nil
+	// Signing out without a session is not an error; the cookie is expired either way
+	err = deleteSession(w, r, h.sessions)
+	if err != nil {
+		ReturnError(w, newAPIError(http.StatusInternalServerError, ErrCodeInternal, "could not delete session", err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Converts a session into the format returned to the client. Tokens are never included.
+func newAuthUserResponse(session *Session) AuthUserResponse {
+	return AuthUserResponse{
+		TokenType:      session.TokenType,
+		TokenExpiresIn: int(time.Until(session.ExpiresAt).Seconds()),
+		UserId:         session.UserID,
+		Username:       session.Username,
+		UserGivenName:  session.GivenName,
+	}
+}
+
+func getAccessToken(ctx context.Context, authCode string) (*AccessTokenResponse, error) {
+	formData := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {authCode},
+		"redirect_uri": {os.Getenv("LBOXD_REDIRECT_URL")},
+	}
+
+	return requestAccessToken(ctx, formData)
+}
+
+func refreshAccessToken(ctx context.Context, refreshToken string) (*AccessTokenResponse, error) {
+	formData := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	}
+
+	return requestAccessToken(ctx, formData)
+}
+
+// Performs the form POST to the auth/token endpoint, adding our client credentials to the given grant
+func requestAccessToken(ctx context.Context, formData url.Values) (*AccessTokenResponse, error) {
+	formData.Set("client_id", os.Getenv("LBOXD_KEY"))
+	formData.Set("client_secret", os.Getenv("LBOXD_SECRET"))
+
+	token, err := newLetterboxdClient("").AuthToken(ctx, formData)
+
+	// The auth code or refresh token is rejected with 400 Bad Request, or our client credentials with 401 Unauthorized
+	var upstreamErr *letterboxd.UpstreamError
+	if errors.As(err, &upstreamErr) && (upstreamErr.StatusCode == http.StatusBadRequest || upstreamErr.StatusCode == http.StatusUnauthorized) {
+		return nil, newAPIError(http.StatusUnauthorized, ErrCodeLetterboxdUnauthorized, "Letterboxd rejected the authorisation; please sign in again", err)
+	}
+	return token, err
+}
+
+func getMemberId(ctx context.Context, lc *letterboxd.Client) (*Member, error) {
+	return lc.Me(ctx)
 }
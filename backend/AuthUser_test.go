@@ -0,0 +1,147 @@
+package colorboxd
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// Serves the auth/token and /me endpoints, accepting the auth code "goodCode" and refresh token "goodRefresh"
+func authServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /auth/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("client_id") != "key" {
+			http.Error(w, "invalid client", http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Form.Get("grant_type") == "authorization_code" && r.Form.Get("code") == "goodCode":
+			json.NewEncoder(w).Encode(AccessTokenResponse{AccessToken: "firstToken", TokenType: "Bearer", RefreshToken: "goodRefresh", ExpiresIn: 3600})
+		case r.Form.Get("grant_type") == "refresh_token" && r.Form.Get("refresh_token") == "goodRefresh":
+			json.NewEncoder(w).Encode(AccessTokenResponse{AccessToken: "newToken", TokenType: "Bearer", ExpiresIn: 3600})
+		default:
+			http.Error(w, "invalid grant", http.StatusBadRequest)
+		}
+	})
+	mux.HandleFunc("GET /me", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"member":{"id":"67W7X","username":"tester","givenName":"Test"}}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// Sets the environment needed by the handlers to talk to the given fake Letterboxd server and miniredis
+func setTestEnv(t *testing.T, lboxdURL string) *miniredis.Miniredis {
+	s := miniredis.RunT(t)
+	t.Setenv("ENVIRONMENT", "test")
+	t.Setenv("LBOXD_BASEURL", lboxdURL)
+	t.Setenv("LBOXD_KEY", "key")
+	t.Setenv("REDIS_URL", fmt.Sprintf("redis://%s", s.Addr()))
+	t.Setenv("SESSION_KEY", base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef")))
+	return s
+}
+
+func TestAuthUserCreatesSession(t *testing.T) {
+	assert := assert.New(t)
+	srv := authServer(t)
+	setTestEnv(t, srv.URL)
+	h := newTestHandlers(t)
+
+	w := httptest.NewRecorder()
+	h.AuthUser(w, httptest.NewRequest("GET", "/api/v1/auth?authCode=badCode", nil))
+	assert.Equal(http.StatusUnauthorized, w.Code)
+	assert.Contains(w.Body.String(), ErrCodeLetterboxdUnauthorized)
+	assert.Empty(w.Result().Cookies())
+
+	w = httptest.NewRecorder()
+	h.AuthUser(w, httptest.NewRequest("GET", "/api/v1/auth?authCode=goodCode", nil))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.NotContains(w.Body.String(), "firstToken")
+	assert.NotContains(w.Body.String(), "goodRefresh")
+
+	cookies := w.Result().Cookies()
+	assert.Len(cookies, 1)
+	assert.Equal(sessionCookieName, cookies[0].Name)
+	assert.True(cookies[0].HttpOnly)
+
+	// The cookie resolves to a session holding the tokens
+	r := httptest.NewRequest("GET", "/api/v1/lists", nil)
+	r.AddCookie(cookies[0])
+	_, session, err := getSession(r, newTestRedis(t))
+	assert.Nil(err)
+	assert.Equal("firstToken", session.AccessToken)
+	assert.Equal("goodRefresh", session.RefreshToken)
+	assert.Equal("67W7X", session.UserID)
+}
+
+func TestRefreshAuth(t *testing.T) {
+	assert := assert.New(t)
+	srv := authServer(t)
+	setTestEnv(t, srv.URL)
+	h := newTestHandlers(t)
+
+	w := httptest.NewRecorder()
+	h.RefreshAuth(w, httptest.NewRequest("POST", "/api/v1/auth/refresh", nil))
+	assert.Equal(http.StatusUnauthorized, w.Code, "no session cookie")
+
+	cookie := newTestSession(t, &Session{UserID: "67W7X", Username: "tester", GivenName: "Test", AccessToken: "oldToken", RefreshToken: "goodRefresh"})
+	r := httptest.NewRequest("POST", "/api/v1/auth/refresh", nil)
+	r.AddCookie(cookie)
+	w = httptest.NewRecorder()
+	h.RefreshAuth(w, r)
+	assert.Equal(http.StatusOK, w.Code)
+
+	var res AuthUserResponse
+	assert.Nil(json.NewDecoder(w.Body).Decode(&res))
+	assert.Equal("67W7X", res.UserId)
+	assert.Equal("Bearer", res.TokenType)
+	assert.InDelta(3600, res.TokenExpiresIn, 5)
+
+	_, session, err := getSession(r, newTestRedis(t))
+	assert.Nil(err)
+	assert.Equal("newToken", session.AccessToken)
+	assert.Equal("goodRefresh", session.RefreshToken)
+
+	cookie = newTestSession(t, &Session{UserID: "67W7X", AccessToken: "oldToken", RefreshToken: "badRefresh"})
+	r = httptest.NewRequest("POST", "/api/v1/auth/refresh", nil)
+	r.AddCookie(cookie)
+	w = httptest.NewRecorder()
+	h.RefreshAuth(w, r)
+	assert.Equal(http.StatusUnauthorized, w.Code, "rejected refresh token")
+}
+
+func TestSignOut(t *testing.T) {
+	assert := assert.New(t)
+	setTestEnv(t, "")
+	h := newTestHandlers(t)
+
+	cookie := newTestSession(t, &Session{UserID: "67W7X", AccessToken: "token"})
+	r := httptest.NewRequest("POST", "/api/v1/auth/signout", nil)
+	r.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	h.SignOut(w, r)
+	assert.Equal(http.StatusNoContent, w.Code)
+
+	// The cookie is expired, and the session it referred to can no longer be used
+	cookies := w.Result().Cookies()
+	assert.Len(cookies, 1)
+	assert.Equal(sessionCookieName, cookies[0].Name)
+	assert.Negative(cookies[0].MaxAge)
+	assert.True(cookies[0].HttpOnly)
+	_, _, err := getSession(r, newTestRedis(t))
+	assert.ErrorIs(err, errNoSession)
+
+	// Signing out again, or without a session at all, still expires the cookie
+	w = httptest.NewRecorder()
+	h.SignOut(w, httptest.NewRequest("POST", "/api/v1/auth/signout", nil))
+	assert.Equal(http.StatusNoContent, w.Code)
+	assert.Len(w.Result().Cookies(), 1)
+}
@@ -1,20 +1,24 @@
 package colorboxd
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
+
+	"github.com/dsantos747/letterboxd_hue_sort/backend/letterboxd"
 )
 
 // GetLists fetches basic metadata of a users letterboxd lists
-func GetLists(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) GetLists(w http.ResponseWriter, r *http.Request) {
 	var err error
 
 	// Read env variables
 	err = LoadEnv()
 	if err != nil {
-		fmt.Printf("Could not load environment variables from .env file: %v\n", err)
+		ReturnError(w, newAPIError(http.StatusInternalServerError, ErrCodeInternal, "could not load server configuration", err))
 		return
 	}
 
@@ -22,25 +26,43 @@ func GetLists(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", os.Getenv("BASE_URL"))
 	w.Header().Set("Access-Control-Allow-Credentials", "true")
 	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.Header().Set("Vary", "Cookie") // The URL is the same for every user; only the session cookie tells them apart
 
-	// Read accessToken from query url - return error if not present
-	accessToken := r.URL.Query().Get("accessToken")
-	if accessToken == "" {
-		ReturnError(w, "Missing or empty 'accessToken' query parameter", http.StatusBadRequest)
+	// Resolve the user's Letterboxd client from their session
+	lc, session, err := sessionClient(r, h.sessions)
+	if err != nil {
+		returnSessionError(w, err)
 		return
 	}
+	userId := session.UserID
+
+	// If the client asks for a specific page, return just that page along with the cursor to the next one
+	query := r.URL.Query()
+	if query.Has("cursor") || query.Has("perPage") {
+		perPage := listsPerPage
+		if query.Get("perPage") != "" {
+			perPage, err = strconv.Atoi(query.Get("perPage"))
+			if err != nil || perPage < 1 || perPage > listsPerPage {
+				ReturnError(w, badRequest(fmt.Sprintf("'perPage' query parameter must be an integer between 1 and %d", listsPerPage)))
+				return
+			}
+		}
 
-	// Get Member id
-	userId := r.URL.Query().Get("userId")
-	if userId == "" {
-		ReturnError(w, "Missing or empty 'userId' query parameter", http.StatusBadRequest)
+		page, err := lc.Lists(r.Context(), userId, query.Get("cursor"), perPage)
+		if err != nil {
+			ReturnError(w, apiErrorOr(err, http.StatusBadGateway, ErrCodeLetterboxdError, "could not retrieve lists from Letterboxd API"))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page)
 		return
 	}
 
 	// Get User Lists
-	userLists, err := getUserLists(accessToken, userId)
+	userLists, err := getUserLists(r.Context(), lc, userId)
 	if err != nil {
-		ReturnError(w, fmt.Errorf("could not retrieve lists from Letterboxd API: %w", err).Error(), http.StatusInternalServerError)
+		ReturnError(w, apiErrorOr(err, http.StatusBadGateway, ErrCodeLetterboxdError, "could not retrieve lists from Letterboxd API"))
 		return
 	}
 
@@ -49,26 +71,27 @@ func GetLists(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(userLists)
 }
 
-func getUserLists(token, id string) (*[]ListSummary, error) {
-	method := "GET"
-	endpoint := fmt.Sprintf("%s/lists", os.Getenv("LBOXD_BASEURL"))
-	query := fmt.Sprintf("?member=%s&memberRelationship=Owner&perPage=100", id)
-	url := endpoint + query
-	headers := map[string]string{"Authorization": fmt.Sprintf("Bearer %s", token)} // Is this actually necessary?
+// The maximum page size accepted by the Letterboxd /lists endpoint
+const listsPerPage = 100
 
-	response, err := MakeHTTPRequest(method, url, nil, headers)
-	if err != nil {
-		return nil, err
-	}
-	defer response.Body.Close()
+// Fetches all of a member's lists, following the pagination cursor until no further pages remain
+func getUserLists(ctx context.Context, lc *letterboxd.Client, id string) (*[]ListSummary, error) {
+	lists := []ListSummary{}
+	cursor := ""
 
-	var responseData ListsResponse
-	err = json.NewDecoder(response.Body).Decode(&responseData)
-	if err != nil {
-		return nil, err
-	}
+	for {
+		responseData, err := lc.Lists(ctx, id, cursor, listsPerPage)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, responseData.Items...)
 
-	var lists = responseData.Items
+		// Guard against the API handing back the cursor we just used, which would loop forever
+		if responseData.Next == "" || responseData.Next == cursor {
+			break
+		}
+		cursor = responseData.Next
+	}
 
 	return &lists, nil
 }
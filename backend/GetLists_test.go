@@ -0,0 +1,61 @@
+package colorboxd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/dsantos747/letterboxd_hue_sort/backend/letterboxd"
+	"github.com/stretchr/testify/assert"
+)
+
+// Serves n lists in pages, linked by a "start=N" cursor in the "next" field like the Letterboxd API
+func pagedListsServer(n int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Query().Get("cursor"), "start="))
+		perPage, _ := strconv.Atoi(r.URL.Query().Get("perPage"))
+
+		items := []map[string]string{}
+		for i := start; i < min(start+perPage, n); i++ {
+			items = append(items, map[string]string{"id": fmt.Sprintf("list%d", i)})
+		}
+		res := map[string]any{"items": items}
+		if start+perPage < n {
+			res["next"] = fmt.Sprintf("start=%d", start+perPage)
+		}
+		json.NewEncoder(w).Encode(res)
+	}))
+}
+
+func TestGetUserListsFollowsCursor(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, n := range []int{0, 1, 100, 101, 250} {
+		srv := pagedListsServer(n)
+
+		lists, err := getUserLists(context.Background(), letterboxd.New(srv.URL, letterboxd.StaticToken("abc")), "67W7X")
+		assert.Nil(err)
+		assert.Len(*lists, n)
+		for i, l := range *lists {
+			assert.Equal(fmt.Sprintf("list%d", i), l.ID)
+		}
+
+		srv.Close()
+	}
+}
+
+func TestGetUserListsRepeatedCursor(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(ListsResponse{Next: "stuck", Items: []ListSummary{{ID: "list"}}})
+	}))
+	defer srv.Close()
+
+	lists, err := getUserLists(context.Background(), letterboxd.New(srv.URL, nil), "67W7X")
+	assert.Nil(t, err)
+	assert.Len(t, *lists, 2)
+}
@@ -0,0 +1,28 @@
+package colorboxd
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+)
+
+// GetSorts lists the available sort algorithms, so the UI doesn't need to hard-code them
+func GetSorts(w http.ResponseWriter, r *http.Request) {
+	var err error
+
+	// Read env variables
+	err = LoadEnv()
+	if err != nil {
+		ReturnError(w, newAPIError(http.StatusInternalServerError, ErrCodeInternal, "could not load server configuration", err))
+		return
+	}
+
+	// Set necessary headers for CORS and cache policy
+	w.Header().Set("Access-Control-Allow-Origin", os.Getenv("BASE_URL"))
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+
+	// Return response to client
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sortAlgorithms)
+}
@@ -4,6 +4,7 @@ import (
 	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	"log/slog"
@@ -11,13 +12,14 @@ import (
 	"net/url"
 	"os"
 	"slices"
-	"strings"
+	"strconv"
 	"sync"
 
 	// Accepted image formats in loadImage
 	_ "image/jpeg"
 	_ "image/png"
 
+	"github.com/dsantos747/letterboxd_hue_sort/backend/letterboxd"
 	"github.com/dsantos747/letterboxd_hue_sort/backend/redis"
 
 	prominentcolor "github.com/EdlinOrg/prominentcolor"
@@ -27,72 +29,79 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
-var rc redis.Redis
-
 // SortListById computes the color information of each movie poster in
 // a user's Letterboxd list and consequently computes the different sort rankings.
-func SortListById(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) SortListById(w http.ResponseWriter, r *http.Request) {
 	var err error
-	ctx := context.Background() // Hack for now
-
-	l := slog.Default()
+	ctx := r.Context() // Stops fetching entries and posters if the client goes away
 
 	// Read env variables
 	err = LoadEnv()
 	if err != nil {
-		fmt.Printf("Could not load environment variables from .env file: %v\n", err)
+		ReturnError(w, newAPIError(http.StatusInternalServerError, ErrCodeInternal, "could not load server configuration", err))
 		return
 	}
 
-	rc = redis.New(os.Getenv("REDIS_URL"))
-
 	// Set necessary headers for CORS and cache policy
 	w.Header().Set("Access-Control-Allow-Origin", os.Getenv("BASE_URL"))
 	w.Header().Set("Access-Control-Allow-Credentials", "true")
 	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.Header().Set("Vary", "Cookie") // The URL is the same for every user; only the session cookie tells them apart
 
-	// Read accessToken from query url - return error if not present
-	accessToken := r.URL.Query().Get("accessToken")
-	if accessToken == "" {
-		ReturnError(w, "Missing or empty 'accessToken' query parameter", http.StatusBadRequest)
+	// Resolve the user's Letterboxd client from their session
+	lc, _, err := sessionClient(r, h.sessions)
+	if err != nil {
+		returnSessionError(w, err)
 		return
 	}
 
 	// Get List id
 	listId := r.URL.Query().Get("listId")
 	if listId == "" {
-		ReturnError(w, "Missing or empty 'listId' query parameter", http.StatusBadRequest)
+		ReturnError(w, badRequest("Missing or empty 'listId' query parameter"))
+		return
+	}
+
+	// Resolve the sort order to respond with, exactly as WriteList would
+	sortFunction, err := resolveSortFunction(r.URL.Query().Get("sortMethod"))
+	if err != nil {
+		ReturnError(w, badRequest(fmt.Errorf("invalid 'sortMethod' query parameter: %w", err).Error()))
+		return
+	}
+	reverse := false
+	if reverseStr := r.URL.Query().Get("reverse"); reverseStr != "" {
+		reverse, err = strconv.ParseBool(reverseStr)
+		if err != nil {
+			ReturnError(w, badRequest("'reverse' query parameter must be a boolean"))
+			return
+		}
+	}
+	if err := checkSortArrangement(r.URL.Query().Get("sortMethod"), 0, reverse); err != nil {
+		ReturnError(w, err)
 		return
 	}
 
 	// Get Entries from List
-	listEntries, err := getListEntries(ctx, accessToken, listId)
+	listEntries, err := getListEntries(ctx, lc, listId)
 	if err != nil {
-		l.Error("failed to retrieve entries from list", "err", err)
-		ReturnError(w, "failed to retrieve entries from list", http.StatusInternalServerError)
+		ReturnError(w, apiErrorOr(err, http.StatusBadGateway, ErrCodeLetterboxdError, "failed to retrieve entries from list"))
 		return
 	}
 
-	entriesWithImageInfo, err := processListImagesV3(ctx, listEntries)
+	entriesWithImageInfo, err := processListImagesV3(ctx, h.colors, listEntries)
 	if err != nil {
-		l.Error("failed to process posters for list entries", "err", err)
-		ReturnError(w, "failed to process posters for list entries", http.StatusInternalServerError)
+		ReturnError(w, apiErrorOr(err, http.StatusInternalServerError, ErrCodeInternal, "failed to process posters for list entries"))
 		return
 	}
 
 	entriesWithRanking, err := assignListRankings(entriesWithImageInfo)
 	if err != nil {
-		l.Error("failed assigning sort rankings for list", "err", err)
-		ReturnError(w, "failed assigning sort rankings for list", http.StatusInternalServerError)
+		ReturnError(w, newAPIError(http.StatusInternalServerError, ErrCodeInternal, "failed assigning sort rankings for list", err))
 		return
 	}
 
-	slices.SortFunc[[]Entry](*entriesWithRanking, func(a, b Entry) int {
-		return cmp.Compare[int](AlgoHue(a.ImageInfo.Colors), AlgoHue(b.ImageInfo.Colors))
-	})
-
 	response := map[string][]Entry{
-		"items": *entriesWithRanking,
+		"items": orderEntries(*entriesWithRanking, sortFunction, reverse),
 	}
 
 	// Return response to client
@@ -100,33 +109,44 @@ func SortListById(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func getFilmCount(token, id string) (int, error) {
-	method := "GET"
-	headers := map[string]string{"Authorization": fmt.Sprintf("Bearer %s", token)}
-	endpoint := fmt.Sprintf("%s/list/%s", os.Getenv("LBOXD_BASEURL"), id)
+// Returns the entries in the order they would be written to the list by WriteList, with no offset
+func orderEntries(entries []Entry, sortFunction func(Entry, Entry) int, reverse bool) []Entry {
+	slices.SortFunc(entries, sortFunction)
 
-	response, err := MakeHTTPRequest(method, endpoint, nil, headers)
-	if err != nil {
-		return 0, fmt.Errorf("error making HTTP request: %v", err)
+	n := len(entries)
+	ordered := make([]Entry, n)
+	for i, entry := range entries {
+		ordered[sortedPosition(i, n, 0, reverse)] = entry
 	}
-	defer response.Body.Close()
+	return ordered
+}
 
-	var responseData List
-	if err = json.NewDecoder(response.Body).Decode(&responseData); err != nil {
-		return 0, fmt.Errorf("error decoding letterboxd list metadata JSON response: %v", err)
+func getFilmCount(ctx context.Context, lc *letterboxd.Client, id string) (int, error) {
+	responseData, err := getList(ctx, lc, id)
+	if err != nil {
+		return 0, err
 	}
 
 	return int(responseData.FilmCount), nil
 }
 
-// For a given list id, returns a slice of each entry in the list
-func getListEntries(ctx context.Context, token, id string) (*[]Entry, error) {
-	method := "GET"
-	endpoint := fmt.Sprintf("%s/list/%s/entries", os.Getenv("LBOXD_BASEURL"), id)
-	headers := map[string]string{"Authorization": fmt.Sprintf("Bearer %s", token)}
-	var listEntriesData []ListEntries
+// Fetches a list's metadata. A list Letterboxd doesn't have is reported as list_not_found.
+func getList(ctx context.Context, lc *letterboxd.Client, id string) (*List, error) {
+	responseData, err := lc.List(ctx, id)
+	var upstreamErr *letterboxd.UpstreamError
+	if errors.As(err, &upstreamErr) && upstreamErr.StatusCode == http.StatusNotFound {
+		return nil, newAPIError(http.StatusNotFound, ErrCodeListNotFound, "list not found", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching letterboxd list metadata: %w", err)
+	}
+
+	return responseData, nil
+}
 
-	filmCount, err := getFilmCount(token, id)
+// For a given list id, returns a slice of each entry in the list
+func getListEntries(ctx context.Context, lc *letterboxd.Client, id string) (*[]Entry, error) {
+	filmCount, err := getFilmCount(ctx, lc, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get list length: %w", err)
 	}
@@ -138,34 +158,29 @@ func getListEntries(ctx context.Context, token, id string) (*[]Entry, error) {
 	curr := 0
 	done := false
 
+	// Pages are fetched concurrently, so each is kept in its own slot to preserve the list order
+	pages := make([][]ListEntries, (filmCount+perPage-1)/perPage)
+
 	// Loop until no "next" pagination cursor is present in response
-	for filmCount > 0 {
-		query := fmt.Sprintf("?cursor=%s&perPage=%d", fmt.Sprintf("start=%d", curr), perPage)
-		url := endpoint + query
+	for page := range pages {
+		cursor := fmt.Sprintf("start=%d", curr)
 
 		errGroup.Go(func() error {
-			response, err := MakeHTTPRequest(method, url, nil, headers)
+			responseData, err := lc.ListEntries(ctx, id, cursor, perPage)
 			if err != nil {
-				return fmt.Errorf("error making HTTP request: %v", err)
-			}
-			defer response.Body.Close()
-
-			var responseData ListEntriesResponse
-			if err = json.NewDecoder(response.Body).Decode(&responseData); err != nil {
-				return fmt.Errorf("error decoding letterboxd list entries JSON response: %v", err)
+				return fmt.Errorf("error fetching letterboxd list entries: %w", err)
 			}
 
 			mu.Lock()
-			listEntriesData = append(listEntriesData, responseData.Items...)
-			mu.Unlock()
-
+			pages[page] = responseData.Items
 			if len(responseData.Next) == 0 { // check we have done them all. Note this might not be the last routine to be processed
 				done = true
 			}
+			mu.Unlock()
+
 			return nil
 		})
 
-		filmCount -= perPage
 		curr += perPage
 	}
 
@@ -176,6 +191,7 @@ func getListEntries(ctx context.Context, token, id string) (*[]Entry, error) {
 	if !done {
 		return nil, fmt.Errorf("failed to retrieve all list entries%s", "")
 	}
+	listEntriesData := slices.Concat(pages...)
 
 	// Extract relevant info from each item into []Entry format
 	n := len(listEntriesData)
@@ -216,42 +232,48 @@ func getListEntries(ctx context.Context, token, id string) (*[]Entry, error) {
 	return &entries, nil
 }
 
-func processListImagesV3(ctx context.Context, listEntries *[]Entry) (*[]Entry, error) {
-	// First we query Redis
+func processListImagesV3(ctx context.Context, cache ColorCache, listEntries *[]Entry) (*[]Entry, error) {
+	// First we query the cache
 	keys := []string{}
 	for _, entry := range *listEntries {
 		keys = append(keys, entry.CacheKey)
 	}
 
-	res, err := rc.GetBatch(keys)
+	// The cache only saves work, so if it can't be reached every poster is processed instead
+	res, err := cache.GetBatch(keys)
 	if err != nil {
-		return nil, fmt.Errorf("failed to lookup keys in redis: %w", err)
+		slog.Default().Warn("colour cache unavailable; processing posters uncached", "err", err)
+		res = map[string]redis.CacheResponse{}
 	}
 
 	// We pass through and append all cache hits
-	var entries, entriesToLoad []Entry
+	var entries, entriesToLoad, entriesToRecompute []Entry
 	for _, e := range *listEntries {
 		entry := e
 
-		// Append entries fetched from cache
+		// Append entries fetched from cache. Stale colours are served for now, and recomputed in the background.
 		if res[entry.CacheKey].Hit {
 			entry.ImageInfo.Colors = parseColors(res[entry.CacheKey].Colors, res[entry.CacheKey].Counts)
 			entries = append(entries, entry)
+			if isStale(res[entry.CacheKey]) {
+				entriesToRecompute = append(entriesToRecompute, entry)
+			}
 			continue
 		}
 
 		entriesToLoad = append(entriesToLoad, entry)
 	}
+	recomputer.enqueue(cache, entriesToRecompute)
 
 	// Then we go through the process of fetch images that we are missing
 	errGroup, ctx := errgroup.WithContext(ctx)
 	rl := ratelimit.New(500)
 	mu := sync.Mutex{}
 	rlCtx, rlCancel := context.WithCancel(ctx) // This is a hack to cancel all goroutines if we get rate-limited when loading images
+	defer rlCancel()
 
 	var c_keys []string
-	var c_colors [][]string
-	var c_counts [][]int
+	var c_values []redis.ColorValue
 	for _, e := range entriesToLoad {
 		// Process any entries not available in cache
 		errGroup.Go(func() error {
@@ -266,10 +288,10 @@ func processListImagesV3(ctx context.Context, listEntries *[]Entry) (*[]Entry, e
 
 			img, err := loadImage(e.ImageInfo.Path)
 			if err != nil {
-				if strings.Contains(err.Error(), "error fetching image from letterboxd servers") {
+				if code := toAPIError(err).Code; code == ErrCodePosterFetchFailed || code == ErrCodeRateLimited {
 					rlCancel()
 				}
-				return fmt.Errorf("error loading image %s: %v", e.ImageInfo.Path, err)
+				return fmt.Errorf("error loading image %s: %w", e.ImageInfo.Path, err)
 			}
 
 			entry, err := getImageInfo(e, img)
@@ -277,16 +299,9 @@ func processListImagesV3(ctx context.Context, listEntries *[]Entry) (*[]Entry, e
 				return fmt.Errorf("error getting image color info for poster for %s: %v", entry.Name, err)
 			}
 
-			colors, counts := []string{}, []int{}
-			for _, c := range entry.ImageInfo.Colors {
-				colors = append(colors, c.hex)
-				counts = append(counts, c.count)
-			}
-
 			mu.Lock()
 			c_keys = append(c_keys, entry.CacheKey)
-			c_colors = append(c_colors, colors)
-			c_counts = append(c_counts, counts)
+			c_values = append(c_values, colorValue(entry.ImageInfo.Colors))
 
 			entries = append(entries, *entry)
 			mu.Unlock()
@@ -298,7 +313,7 @@ func processListImagesV3(ctx context.Context, listEntries *[]Entry) (*[]Entry, e
 	egErr := errGroup.Wait()
 	if len(keys) > 0 { // Even if we fail to process all, set to cache what we did manage
 		go func() {
-			rc.SetBatch(c_keys, c_colors, c_counts)
+			cache.SetBatch(c_keys, c_values)
 		}()
 	}
 	if egErr != nil { // Then handle the error
@@ -309,7 +324,7 @@ func processListImagesV3(ctx context.Context, listEntries *[]Entry) (*[]Entry, e
 }
 
 // This v2 method bypasses the whole worker pattern and just uses a good old errgroup. NEEDS TO BE TESTED
-func processListImagesV2(listEntries *[]Entry) (*[]Entry, error) {
+func processListImagesV2(cache ColorCache, listEntries *[]Entry) (*[]Entry, error) {
 	var entries []Entry
 
 	ctx := context.Background() // Hack for now
@@ -319,7 +334,7 @@ func processListImagesV2(listEntries *[]Entry) (*[]Entry, error) {
 	for _, e := range *listEntries {
 		errGroup.Go(func() error {
 
-			res, err := rc.Get(e.CacheKey)
+			res, err := cache.Get(e.CacheKey)
 			if err != nil {
 				return fmt.Errorf("failed to fetch from redis: %w", err)
 			}
@@ -331,7 +346,7 @@ func processListImagesV2(listEntries *[]Entry) (*[]Entry, error) {
 			} else {
 				img, err := loadImage(e.ImageInfo.Path)
 				if err != nil {
-					return fmt.Errorf("error loading image %s: %v", e.ImageInfo.Path, err)
+					return fmt.Errorf("error loading image %s: %w", e.ImageInfo.Path, err)
 				}
 
 				entry, err := getImageInfo(e, img)
@@ -340,12 +355,7 @@ func processListImagesV2(listEntries *[]Entry) (*[]Entry, error) {
 				}
 
 				go func() {
-					colors, counts := []string{}, []int{}
-					for _, c := range entry.ImageInfo.Colors {
-						colors = append(colors, c.hex)
-						counts = append(counts, c.count)
-					}
-					rc.Set(entry.CacheKey, colors, counts)
+					cache.Set(entry.CacheKey, colorValue(entry.ImageInfo.Colors))
 				}()
 
 				entries = append(entries, *entry)
@@ -363,7 +373,7 @@ func processListImagesV2(listEntries *[]Entry) (*[]Entry, error) {
 
 // For a slice of entries, this creates some goroutines which download the poster and extract colour
 // information for each film. workerCount can be used to adjust the amount of goroutines.
-func processListImages(listEntries *[]Entry) (*[]Entry, error) {
+func processListImages(cache ColorCache, listEntries *[]Entry) (*[]Entry, error) {
 	var entrySlice []Entry
 	n := len(*listEntries)
 
@@ -374,7 +384,7 @@ func processListImages(listEntries *[]Entry) (*[]Entry, error) {
 	workerCount := 200 // Consider adjusting this based on list size; ask letterboxd team about rate limiting on their servers
 
 	for i := 0; i < workerCount; i++ {
-		go worker(imageChan, colorChan, &wg, errChan)
+		go worker(cache, imageChan, colorChan, &wg, errChan)
 	}
 
 	for _, entry := range *listEntries {
@@ -397,12 +407,12 @@ func processListImages(listEntries *[]Entry) (*[]Entry, error) {
 
 // This worker (pool size limited by workerCount) listens on imageChan, downloads the image and
 // extracts the colour information, then returns the populated Entry to colorChan
-func worker(imageChan <-chan Image, colorChan chan<- Entry, wg *sync.WaitGroup, errChan chan<- error) {
+func worker(cache ColorCache, imageChan <-chan Image, colorChan chan<- Entry, wg *sync.WaitGroup, errChan chan<- error) {
 	for image := range imageChan {
 		// Here need to first check redis cache for image info
 		entry := &image.info
 
-		res, err := rc.Get(image.info.CacheKey)
+		res, err := cache.Get(image.info.CacheKey)
 		if err != nil {
 			errChan <- fmt.Errorf("failed to fetch from redis: %w", err)
 			continue
@@ -433,12 +443,7 @@ func worker(imageChan <-chan Image, colorChan chan<- Entry, wg *sync.WaitGroup,
 			// The letterboxd api has a posterPickerUrl, which is to do with the custom poster chosen in a list. Could we use this?
 			// Maybe, check if that field is empty or not when the poster is standard, that could be useful
 			// Once you have the api key back, can use that to make some postman calls and check
-			colors, counts := []string{}, []int{}
-			for _, c := range entry.ImageInfo.Colors {
-				colors = append(colors, c.hex)
-				counts = append(counts, c.count)
-			}
-			rc.Set(image.info.CacheKey, colors, counts)
+			cache.Set(image.info.CacheKey, colorValue(entry.ImageInfo.Colors))
 		}
 
 		colorChan <- *entry
@@ -452,13 +457,17 @@ func loadImage(path string) (image.Image, error) {
 
 	response, err := MakeHTTPRequest("GET", path, nil, nil)
 	if err != nil {
-		return nil, fmt.Errorf("error fetching image from letterboxd servers: %w", err)
+		var upstreamErr *letterboxd.UpstreamError
+		if errors.As(err, &upstreamErr) && upstreamErr.StatusCode == http.StatusTooManyRequests {
+			return nil, fmt.Errorf("error fetching image from letterboxd servers: %w", err)
+		}
+		return nil, newAPIError(http.StatusBadGateway, ErrCodePosterFetchFailed, "error fetching image from letterboxd servers", err)
 	}
 	defer response.Body.Close()
 
 	img, _, err := image.Decode(response.Body)
 	if err != nil {
-		return nil, err
+		return nil, newAPIError(http.StatusBadGateway, ErrCodePosterDecodeFailed, "could not decode poster image", err)
 	}
 
 	smallImg := imaging.Resize(img, 80, 0, imaging.NearestNeighbor)
@@ -466,6 +475,11 @@ func loadImage(path string) (image.Image, error) {
 	return smallImg, nil
 }
 
+// Identifies the colour extraction done by getImageInfo, and is stored with the colours it extracts. Bump the
+// version whenever the extraction changes (k, resizing, masking...): colours cached by other versions are then
+// recomputed in the background (see colorRecompute.go).
+const colorExtractionAlgorithm = "prominentcolor-kmeans-k3-nocrop/v1"
+
 // Populate an image with information about its dominant colours
 func getImageInfo(entry Entry, img image.Image) (*Entry, error) {
 	var method int = prominentcolor.ArgumentNoCropping
@@ -512,24 +526,49 @@ func getDominantColors(k, method int, img image.Image) (*[]prominentcolor.ColorI
 	return &res, nil
 }
 
-// This function calculates each poster's ranking according to each sort method (see sortAlgorithms file)
+// This function calculates each poster's ranking according to each registered sort method (see sortRegistry.go)
 func assignListRankings(listEntries *[]Entry) (*[]Entry, error) {
+	// Global orders break ties by index, and the entries arrive in whatever order their posters were
+	// processed, so put them back in list order first to rank posters of the same colour consistently
+	slices.SortFunc(*listEntries, func(a, b Entry) int {
+		return cmp.Compare(a.ListPosition, b.ListPosition)
+	})
+
+	colors := make([][]Color, len(*listEntries))
 	for i, e := range *listEntries {
-		(*listEntries)[i].SortVals.Hue = AlgoHue(e.ImageInfo.Colors)
-		(*listEntries)[i].SortVals.Lum = AlgoLuminosity(e.ImageInfo.Colors)
-		(*listEntries)[i].SortVals.InverseStep_8 = AlgoInverseStep(e.ImageInfo.Colors, 8)
-		(*listEntries)[i].SortVals.InverseStep_12 = AlgoInverseStep(e.ImageInfo.Colors, 12)
-		(*listEntries)[i].SortVals.InverseStep2_8 = AlgoInverseStepV2(e.ImageInfo.Colors, 8)
-		(*listEntries)[i].SortVals.InverseStep2_12 = AlgoInverseStepV2(e.ImageInfo.Colors, 12)
-		(*listEntries)[i].SortVals.BRBW1 = AlgoBRBW1(e.ImageInfo.Colors)
-		(*listEntries)[i].SortVals.BRBW2 = AlgoBRBW2(e.ImageInfo.Colors)
+		if len(e.ImageInfo.Colors) == 0 {
+			return nil, fmt.Errorf("no colour information for poster for %s", e.Name)
+		}
+		colors[i] = e.ImageInfo.Colors
+		(*listEntries)[i].SortVals = make(SortVals, len(sortAlgorithms))
 	}
 
-	// where error handling?
+	for _, algo := range sortAlgorithms {
+		if algo.Global {
+			// The poster's position in the ordering becomes its rank
+			for i, pos := range algo.Order(colors) {
+				(*listEntries)[i].SortVals[algo.Name] = pos
+			}
+			continue
+		}
+		for i := range *listEntries {
+			(*listEntries)[i].SortVals[algo.Name] = algo.Rank(colors[i])
+		}
+	}
 
 	return listEntries, nil
 }
 
+// Returns colours extracted by getImageInfo in the form they are cached
+func colorValue(colors []Color) redis.ColorValue {
+	value := redis.ColorValue{Colors: []string{}, Counts: []int{}, Algorithm: colorExtractionAlgorithm}
+	for _, c := range colors {
+		value.Colors = append(value.Colors, c.hex)
+		value.Counts = append(value.Counts, c.count)
+	}
+	return value
+}
+
 func parseColors(hexes []string, counts []int) []Color {
 	var colors []Color
 	for i, hex := range hexes {
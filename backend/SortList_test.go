@@ -0,0 +1,69 @@
+package colorboxd
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/dsantos747/letterboxd_hue_sort/backend/letterboxd"
+	"github.com/dsantos747/letterboxd_hue_sort/backend/letterboxd/letterboxdtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveSortFunction(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, method := range []string{"", "hue", "lum", "inverseStep_8", "BRBW1"} {
+		_, err := resolveSortFunction(method)
+		assert.Nil(err, method)
+	}
+	for _, method := range []string{"notASort", "ListPosition "} {
+		_, err := resolveSortFunction(method)
+		assert.ErrorContains(err, "not recognized", method)
+	}
+}
+
+// Sorting the same entries must give the same order however they arrive, including when sort values tie
+func TestOrderEntriesDeterministic(t *testing.T) {
+	assert := assert.New(t)
+
+	var entries []Entry
+	for i := range 50 {
+		entries = append(entries, Entry{ListPosition: i, FilmID: string(rune('A' + i)), SortVals: SortVals{"hue": i % 5, "lum": 50 - i}})
+	}
+
+	for _, reverse := range []bool{false, true} {
+		sortFunction, err := resolveSortFunction("hue")
+		assert.Nil(err)
+		expected := orderEntries(append([]Entry{}, entries...), sortFunction, reverse)
+
+		for range 10 {
+			shuffled := append([]Entry{}, entries...)
+			rand.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+			assert.Equal(expected, orderEntries(shuffled, sortFunction, reverse))
+		}
+
+		// Ties on Hue fall back to list position
+		if !reverse {
+			assert.Equal([]int{0, 5, 10}, []int{expected[0].ListPosition, expected[1].ListPosition, expected[2].ListPosition})
+		} else {
+			assert.Equal([]int{0, 49, 44}, []int{expected[0].ListPosition, expected[1].ListPosition, expected[2].ListPosition})
+		}
+	}
+}
+
+// Pages of entries are fetched concurrently, but the entries must keep their order in the list
+func TestGetListEntriesOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	fake := letterboxdtest.NewServer(letterboxdtest.GradientList("long", 250))
+	defer fake.Close()
+
+	entries, err := getListEntries(context.Background(), letterboxd.New(fake.URL, letterboxd.StaticToken(letterboxdtest.AccessToken)), "long")
+	assert.Nil(err)
+	assert.Len(*entries, 250)
+	for i, e := range *entries {
+		assert.Equal(i, e.ListPosition)
+		assert.Equal(fake.FilmIDs("long")[i], e.FilmID)
+	}
+}
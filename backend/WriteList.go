@@ -1,24 +1,29 @@
 package colorboxd
 
 import (
-	"bytes"
+	"cmp"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"math"
 	"net/http"
 	"os"
-	"reflect"
 	"slices"
 	"strings"
+
+	"github.com/dsantos747/letterboxd_hue_sort/backend/letterboxd"
 )
 
 // WriteList writes the sorted list to the users letterboxd account.
-func WriteList(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) WriteList(w http.ResponseWriter, r *http.Request) {
 	var err error
 
 	// Read env variables
 	err = LoadEnv()
 	if err != nil {
-		fmt.Printf("Could not load environment variables from .env file: %v\n", err)
+		ReturnError(w, newAPIError(http.StatusInternalServerError, ErrCodeInternal, "could not load server configuration", err))
 		return
 	}
 
@@ -33,22 +38,73 @@ func WriteList(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := checkJSONRequest(r); err != nil {
+		ReturnError(w, err)
+		return
+	}
+
+	// Resolve the user's Letterboxd client from their session
+	lc, session, err := sessionClient(r, h.sessions)
+	if err != nil {
+		returnSessionError(w, err)
+		return
+	}
+
 	var responseData WriteListRequest
 	err = json.NewDecoder(r.Body).Decode(&responseData)
 	if err != nil {
-		ReturnError(w, fmt.Errorf("failed to decode request data: %w", err).Error(), http.StatusBadRequest)
+		ReturnError(w, badRequest(fmt.Errorf("failed to decode request data: %w", err).Error()))
 		return
 	}
 
-	listUpdateRequest, err := prepareListUpdateRequest(responseData.List, responseData.Offset, responseData.SortMethod, responseData.Reverse)
+	if responseData.ListID == "" {
+		ReturnError(w, badRequest("Missing or empty 'listId' in request data"))
+		return
+	}
+	if _, err = resolveSortFunction(responseData.SortMethod); err != nil {
+		ReturnError(w, badRequest(fmt.Errorf("invalid 'sortMethod' in request data: %w", err).Error()))
+		return
+	}
+	if err := checkSortArrangement(responseData.SortMethod, responseData.Offset, responseData.Reverse); err != nil {
+		ReturnError(w, err)
+		return
+	}
+
+	// The write is planned from the list as it stands on Letterboxd, rather than from the client's copy of it
+	list, err := loadListForWrite(r.Context(), lc, h.colors, responseData.ListID)
 	if err != nil {
-		ReturnError(w, fmt.Errorf("couldn't prepare list update request body: %w", err).Error(), http.StatusInternalServerError)
+		ReturnError(w, apiErrorOr(err, http.StatusBadGateway, ErrCodeLetterboxdError, "couldn't load list"))
 		return
 	}
 
-	message, err := writeListSorting(responseData.AccessToken, responseData.List.ID, *listUpdateRequest)
+	preview, err := prepareListUpdateRequest(list, responseData.Offset, responseData.SortMethod, responseData.Reverse)
 	if err != nil {
-		ReturnError(w, fmt.Errorf("couldn't update user list: %w", err).Error(), http.StatusInternalServerError)
+		ReturnError(w, newAPIError(http.StatusInternalServerError, ErrCodeInternal, "couldn't prepare list update request body", err))
+		return
+	}
+
+	// A dry run only reports what the write would do, leaving the list on Letterboxd untouched
+	if responseData.DryRun {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(preview)
+		return
+	}
+
+	// Keep the list's current order, so that the sort can be undone
+	err = saveListSnapshot(h.redis, session.UserID, newListSnapshot(list))
+	if err != nil {
+		ReturnError(w, newAPIError(http.StatusInternalServerError, ErrCodeInternal, "couldn't save the current list order", err))
+		return
+	}
+
+	progress := newWriteProgress(list.ID, preview.Request)
+	message, err := writeListInBatches(r.Context(), lc, h.redis, session.UserID, progress)
+	if errors.Is(err, errListVersionConflict) {
+		// The list changed on Letterboxd since it was loaded, so plan the write again against its current order
+		message, err = h.retryListWrite(r.Context(), lc, session.UserID, responseData)
+	}
+	if err != nil {
+		ReturnError(w, apiErrorOr(err, http.StatusBadGateway, ErrCodeLetterboxdError, "couldn't update user list"))
 		return
 	}
 
@@ -56,115 +112,369 @@ func WriteList(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(message)
 }
 
-// Sort the list as per the specified method, then return a ListUpdateRequest, as required by Letterboxd endpoint
-func prepareListUpdateRequest(list ListWithEntries, offset int, sortMethod string, reverse bool) (*ListUpdateRequest, error) {
-	generateSortFunction := func(method string) (func(Entry, Entry) int, error) {
-		sortMethod := "Hue"
-		if len(method) > 0 {
-			sortMethod = strings.ToUpper(method[:1]) + method[1:]
-		}
+// Makes a write again, once, after it conflicted with a change made to the list on Letterboxd
+func (h *Handlers) retryListWrite(ctx context.Context, lc *letterboxd.Client, userID string, request WriteListRequest) (*[]string, error) {
+	list, err := loadListForWrite(ctx, lc, h.colors, request.ListID)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't reload list: %w", err)
+	}
 
-		// Check if sortMethod is valid
-		if _, ok := reflect.TypeOf(SortVals{}).FieldByName(sortMethod); !ok {
-			return nil, fmt.Errorf("provided sort method not recognized")
-		}
+	preview, err := prepareListUpdateRequest(list, request.Offset, request.SortMethod, request.Reverse)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't prepare list update request body: %w", err)
+	}
+
+	// The snapshot saved before the first attempt is kept, as the list may since have had batches of this write
+	// applied to it; undoing restores the order the list had before the write began
+	return writeListInBatches(ctx, lc, h.redis, userID, newWriteProgress(list.ID, preview.Request))
+}
+
+// Fetches a list's current version and entries from Letterboxd, and ranks the entries by their poster
+// colours (served from the cache where possible) exactly as SortListById does.
+func loadListForWrite(ctx context.Context, lc *letterboxd.Client, cache ColorCache, id string) (ListWithEntries, error) {
+	metadata, err := getList(ctx, lc, id)
+	if err != nil {
+		return ListWithEntries{}, err
+	}
+
+	listEntries, err := getListEntries(ctx, lc, id)
+	if err != nil {
+		return ListWithEntries{}, fmt.Errorf("failed to retrieve entries from list: %w", err)
+	}
+	entriesWithImageInfo, err := processListImagesV3(ctx, cache, listEntries)
+	if err != nil {
+		return ListWithEntries{}, fmt.Errorf("failed to process posters for list entries: %w", err)
+	}
+	entriesWithRanking, err := assignListRankings(entriesWithImageInfo)
+	if err != nil {
+		return ListWithEntries{}, fmt.Errorf("failed assigning sort rankings for list: %w", err)
+	}
 
-		// Generate the sort function from the type
-		sortFunction := func(a, b Entry) int {
-			A := reflect.ValueOf(a.SortVals).FieldByName(sortMethod).Int()
-			B := reflect.ValueOf(b.SortVals).FieldByName(sortMethod).Int()
-			return int(A - B)
+	summary := ListSummary{ID: metadata.ID, Version: metadata.Version, FilmCount: int(metadata.FilmCount)}
+	return ListWithEntries{ListSummary: summary, Entries: *entriesWithRanking}, nil
+}
+
+// UndoWrite restores the users letterboxd list to the order it was in before it was last sorted.
+func (h *Handlers) UndoWrite(w http.ResponseWriter, r *http.Request) {
+	var err error
+
+	// Read env variables
+	err = LoadEnv()
+	if err != nil {
+		ReturnError(w, newAPIError(http.StatusInternalServerError, ErrCodeInternal, "could not load server configuration", err))
+		return
+	}
+
+	// Set necessary headers for CORS
+	w.Header().Set("Access-Control-Allow-Origin", os.Getenv("BASE_URL"))
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if err := checkJSONRequest(r); err != nil {
+		ReturnError(w, err)
+		return
+	}
+
+	// Resolve the user's Letterboxd client from their session
+	lc, session, err := sessionClient(r, h.sessions)
+	if err != nil {
+		returnSessionError(w, err)
+		return
+	}
+
+	var responseData UndoWriteRequest
+	err = json.NewDecoder(r.Body).Decode(&responseData)
+	if err != nil {
+		ReturnError(w, badRequest(fmt.Errorf("failed to decode request data: %w", err).Error()))
+		return
+	}
+
+	snapshot, found, err := loadListSnapshot(h.redis, session.UserID, responseData.ListID)
+	if err != nil {
+		ReturnError(w, newAPIError(http.StatusInternalServerError, ErrCodeInternal, "couldn't load the previous list order", err))
+		return
+	}
+	if !found {
+		ReturnError(w, newAPIError(http.StatusNotFound, ErrCodeNotFound, "there is no sort to undo for this list", nil))
+		return
+	}
+
+	// The list may have changed since it was sorted, so the undo is planned against its current state
+	list, err := getList(r.Context(), lc, responseData.ListID)
+	if err != nil {
+		ReturnError(w, apiErrorOr(err, http.StatusBadGateway, ErrCodeLetterboxdError, "error fetching letterboxd list metadata"))
+		return
+	}
+	entries, err := getListEntries(r.Context(), lc, responseData.ListID)
+	if err != nil {
+		ReturnError(w, apiErrorOr(err, http.StatusBadGateway, ErrCodeLetterboxdError, "failed to retrieve entries from list"))
+		return
+	}
+
+	listUpdateRequest := prepareUndoRequest(snapshot, *entries, list.Version)
+	message, err := writeListInBatches(r.Context(), lc, h.redis, session.UserID, newWriteProgress(responseData.ListID, listUpdateRequest))
+	if err != nil {
+		ReturnError(w, apiErrorOr(err, http.StatusBadGateway, ErrCodeLetterboxdError, "couldn't update user list"))
+		return
+	}
+
+	if err := h.redis.DeleteListSnapshot(userListKey(session.UserID, responseData.ListID)); err != nil {
+		slog.Default().Warn("failed to remove list snapshot", "list", responseData.ListID, "err", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(message)
+}
+
+// ResumeWrite continues a write to the users letterboxd list that failed part-way through.
+func (h *Handlers) ResumeWrite(w http.ResponseWriter, r *http.Request) {
+	var err error
+
+	// Read env variables
+	err = LoadEnv()
+	if err != nil {
+		ReturnError(w, newAPIError(http.StatusInternalServerError, ErrCodeInternal, "could not load server configuration", err))
+		return
+	}
+
+	// Set necessary headers for CORS
+	w.Header().Set("Access-Control-Allow-Origin", os.Getenv("BASE_URL"))
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if err := checkJSONRequest(r); err != nil {
+		ReturnError(w, err)
+		return
+	}
+
+	// Resolve the user's Letterboxd client from their session
+	lc, session, err := sessionClient(r, h.sessions)
+	if err != nil {
+		returnSessionError(w, err)
+		return
+	}
+
+	var responseData ResumeWriteRequest
+	err = json.NewDecoder(r.Body).Decode(&responseData)
+	if err != nil {
+		ReturnError(w, badRequest(fmt.Errorf("failed to decode request data: %w", err).Error()))
+		return
+	}
+
+	progress, found, err := loadWriteProgress(h.redis, session.UserID, responseData.ListID)
+	if err != nil {
+		ReturnError(w, newAPIError(http.StatusInternalServerError, ErrCodeInternal, "couldn't load write progress", err))
+		return
+	}
+	if !found {
+		ReturnError(w, newAPIError(http.StatusNotFound, ErrCodeNotFound, "there is no unfinished write to resume for this list", nil))
+		return
+	}
+
+	message, err := writeListInBatches(r.Context(), lc, h.redis, session.UserID, progress)
+	if err != nil {
+		ReturnError(w, apiErrorOr(err, http.StatusBadGateway, ErrCodeLetterboxdError, "couldn't update user list"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(message)
+}
+
+// Resolves a sort method name (see sortRegistry.go, e.g. "hue" or "inverseStep_8") into a comparison
+// function over entries. An empty method defaults to hue. Ties are broken by the entries' original
+// list position, so that sorting the same list always produces the same order.
+func resolveSortFunction(method string) (func(Entry, Entry) int, error) {
+	if method == "" {
+		method = defaultSortMethod
+	}
+
+	// Check if sortMethod is valid
+	algo, ok := lookupSortAlgorithm(method)
+	if !ok {
+		return nil, fmt.Errorf("provided sort method not recognized")
+	}
+
+	sortFunction := func(a, b Entry) int {
+		A, B := a.SortVals[algo.Name], b.SortVals[algo.Name]
+		if A != B {
+			return cmp.Compare(A, B)
 		}
+		return cmp.Compare(a.ListPosition, b.ListPosition)
+	}
 
-		return sortFunction, nil
+	return sortFunction, nil
+}
+
+// Returns the final list position of the entry at index i of a sorted list of length n, after the
+// list has been rotated by offset and optionally reversed (the first entry stays first when reversing).
+func sortedPosition(i, n, offset int, reverse bool) int {
+	endPos := ((i + n) - offset) % n
+	if reverse {
+		endPos = (n - endPos) % n
 	}
+	return endPos
+}
 
-	sortFunction, err := generateSortFunction(sortMethod)
+// Sort the list as per the specified method, then return a ListUpdateRequest, as required by Letterboxd endpoint,
+// previewed alongside the list's films in their new order.
+func prepareListUpdateRequest(list ListWithEntries, offset int, sortMethod string, reverse bool) (*WritePreviewResponse, error) {
+	sortFunction, err := resolveSortFunction(sortMethod)
 	if err != nil {
 		return nil, err
 	}
-	slices.SortFunc(list.Entries, sortFunction)
+	entries := slices.Clone(list.Entries)
+	slices.SortFunc(entries, sortFunction)
 
-	n := len(list.Entries)
+	n := len(entries)
 	currentPositions := make(map[string]int)
 	var finishSlice []FilmTargetPosition
+	order := make([]WritePreviewEntry, n)
 
-	for i, entry := range list.Entries {
-		endPos := ((i + n) - offset) % n
-		if reverse {
-			endPos = (n - endPos) % n
-		}
+	for i, entry := range entries {
+		endPos := sortedPosition(i, n, offset, reverse)
 
 		currentPositions[entry.FilmID] = entry.ListPosition
 		finishSlice = append(finishSlice, FilmTargetPosition{entry.FilmID, endPos})
+		order[endPos] = WritePreviewEntry{FilmID: entry.FilmID, Name: entry.Name, OldPosition: entry.ListPosition, NewPosition: endPos}
 	}
 
 	slices.SortFunc(finishSlice, func(a, b FilmTargetPosition) int {
 		return a.Position - b.Position
 	})
 
-	updateEntries := createListUpdateEntries(currentPositions, finishSlice)
-	request := ListUpdateRequest{Version: list.Version, Entries: updateEntries}
+	plan := createListUpdateEntries(currentPositions, finishSlice)
+	slog.Default().Info("planned list update", "list", list.ID, "films", n, "moves", len(plan.Entries), "movesSaved", plan.MovesSaved)
+
+	return &WritePreviewResponse{
+		Request:    ListUpdateRequest{Version: list.Version, Entries: plan.Entries},
+		Order:      order,
+		Moves:      len(plan.Entries),
+		MovesSaved: plan.MovesSaved,
+	}, nil
+}
 
-	return &request, nil
+// The moves needed to rewrite a list into a new order
+type listUpdatePlan struct {
+	Entries    []listUpdateEntry
+	MovesSaved int // How many fewer moves this needs than moving each film into place from the top of the list
 }
 
 // Create a set of instructions that, applied in turn, result in the correctly-sorted list.
-func createListUpdateEntries(currentPositions map[string]int, finishPositions []FilmTargetPosition) []listUpdateEntry {
+//
+// The films whose current positions form the longest increasing subsequence (in target order) are already
+// in the right order relative to each other, so they stay where they are and only the remaining films are
+// moved. Each moved film is placed directly after the film that precedes it in the target order.
+func createListUpdateEntries(currentPositions map[string]int, finishPositions []FilmTargetPosition) listUpdatePlan {
+	n := len(finishPositions)
+	positions := make([]int, n) // Current position of each film, in target order
+	for i, film := range finishPositions {
+		positions[i] = currentPositions[film.FilmId]
+	}
+	stays := longestIncreasingSubsequence(positions)
+
+	// The list as it currently stands, updated as each move is applied
+	order := make([]string, n)
+	for i, film := range finishPositions {
+		order[positions[i]] = film.FilmId
+	}
+
 	var updateEntries []listUpdateEntry
-	for _, film := range finishPositions {
-		currPos := currentPositions[film.FilmId]
-		if film.Position == currPos {
+	for i, film := range finishPositions {
+		if stays[i] {
 			continue
 		}
 
-		updateEntries = append(updateEntries, listUpdateEntry{Action: "UPDATE", Position: currPos, NewPosition: film.Position})
-		currentPositions[film.FilmId] = film.Position
+		currPos := slices.Index(order, film.FilmId)
+		order = slices.Delete(order, currPos, currPos+1)
+		newPos := 0
+		if i > 0 {
+			newPos = slices.Index(order, finishPositions[i-1].FilmId) + 1
+		}
+		order = slices.Insert(order, newPos, film.FilmId)
 
-		for f, cP := range currentPositions {
-			if f != film.FilmId {
-				if film.Position <= cP && cP < currPos {
-					currentPositions[f]++
-				}
-			}
+		if newPos != currPos {
+			updateEntries = append(updateEntries, listUpdateEntry{Action: "UPDATE", Position: currPos, NewPosition: newPos})
 		}
 	}
-	return updateEntries
+
+	return listUpdatePlan{Entries: updateEntries, MovesSaved: topDownMoveCount(positions) - len(updateEntries)}
 }
 
-// Send request to Letterboxd endpoint to update list.
-func writeListSorting(token, id string, listUpdateRequest ListUpdateRequest) (*[]string, error) {
+// Reports which elements of s belong to a longest strictly increasing subsequence of it, in O(n log n).
+func longestIncreasingSubsequence(s []int) []bool {
+	tails := []int{}            // tails[k] is the index of the smallest last element of an increasing subsequence of length k+1
+	prev := make([]int, len(s)) // The index of the element preceding each element in its subsequence, or -1
+	for i, v := range s {
+		k, _ := slices.BinarySearchFunc(tails, v, func(t, v int) int { return cmp.Compare(s[t], v) })
+		prev[i] = -1
+		if k > 0 {
+			prev[i] = tails[k-1]
+		}
+		if k == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[k] = i
+		}
+	}
 
-	// Prepare endpoint and body for PATCH request
-	method := "PATCH"
-	endpoint := fmt.Sprintf("%s/list/%s", os.Getenv("LBOXD_BASEURL"), id)
-	headers := map[string]string{"Authorization": fmt.Sprintf("Bearer %s", token), "Content-Type": "application/json", "X-HTTP-Method-Override": "PATCH"}
-	body, err := json.Marshal(listUpdateRequest)
-	if err != nil {
-		return nil, err
+	inSubsequence := make([]bool, len(s))
+	if len(tails) > 0 {
+		for i := tails[len(tails)-1]; i >= 0; i = prev[i] {
+			inSubsequence[i] = true
+		}
 	}
+	return inSubsequence
+}
 
-	response, err := MakeHTTPRequest(method, endpoint, bytes.NewReader(body), headers)
-	if err != nil {
-		return nil, err
+// The number of moves needed to rewrite a list by moving each film in turn, in target order, to its final
+// position. Given the current positions in target order, a film is left alone only if it is already ahead
+// of every film that comes after it in the target order.
+func topDownMoveCount(positions []int) int {
+	moves := 0
+	lowest := math.MaxInt
+	for i := len(positions) - 1; i >= 0; i-- {
+		if positions[i] > lowest {
+			moves++
+		}
+		lowest = min(lowest, positions[i])
 	}
-	defer response.Body.Close()
+	return moves
+}
 
-	var responseData ListUpdateResponse
-	if err = json.NewDecoder(response.Body).Decode(&responseData); err != nil {
-		return nil, err
+// Returned when Letterboxd rejects an update because the list has changed since the update was planned
+var errListVersionConflict = errors.New("the list has changed on letterboxd since it was loaded")
+
+// Send request to Letterboxd endpoint to update list, returning the version of the list after the update.
+func writeListSorting(ctx context.Context, lc *letterboxd.Client, id string, listUpdateRequest ListUpdateRequest) (int, error) {
+	responseData, err := lc.PatchList(ctx, id, listUpdateRequest)
+	if err != nil {
+		return 0, err
 	}
 
-	var message []string
 	if len(responseData.Messages) != 0 {
+		var message []string
 		for _, m := range responseData.Messages {
 			message = append(message, fmt.Sprintf("%s: %s - %s", m.Type, m.Code, m.Title))
 		}
-		errorStr := "The letterboxd API responded with the following errors: " + strings.Join(message, "; ")
-		return &message, fmt.Errorf(errorStr)
+		err = fmt.Errorf("The letterboxd API responded with the following errors: %s", strings.Join(message, "; "))
+		if slices.ContainsFunc(responseData.Messages, func(m ListUpdateMessage) bool { return m.Code == letterboxd.MessageListVersionMismatch }) {
+			err = fmt.Errorf("%w: %w", errListVersionConflict, err)
+		}
+		return 0, err
 	}
 
-	message = []string{"List updated successfully"}
-
-	return &message, nil
+	return responseData.Data.Version, nil
 }
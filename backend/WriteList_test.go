@@ -0,0 +1,152 @@
+package colorboxd
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+
+	"github.com/dsantos747/letterboxd_hue_sort/backend/letterboxd"
+	"github.com/dsantos747/letterboxd_hue_sort/backend/letterboxd/letterboxdtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// A randomly generated list and write request, for property-based tests
+type writeCase struct {
+	Ranks      []int // The rank of each film under the "hue" sort; small so that ties are common
+	Offset     int
+	Reverse    bool
+	SortMethod string
+}
+
+func (writeCase) Generate(r *rand.Rand, size int) reflect.Value {
+	n := 1 + r.Intn(3*size)
+	tc := writeCase{Ranks: make([]int, n), Offset: r.Intn(n), Reverse: r.Intn(2) == 0, SortMethod: "hue"}
+	for i := range tc.Ranks {
+		tc.Ranks[i] = r.Intn(n/2 + 1)
+	}
+	return reflect.ValueOf(tc)
+}
+
+// Builds a list on a fake server matching the case, and the entries the client would hold for it
+func (tc writeCase) setup() (*letterboxdtest.Server, ListWithEntries) {
+	fakeList := &letterboxdtest.List{ID: "prop", Version: 1 + len(tc.Ranks)}
+	list := ListWithEntries{ListSummary: ListSummary{ID: fakeList.ID, Version: fakeList.Version}}
+	for i, rank := range tc.Ranks {
+		id := fmt.Sprintf("film%d", i)
+		fakeList.Films = append(fakeList.Films, letterboxdtest.Film{ID: id, Colors: []string{"#000000"}})
+		list.Entries = append(list.Entries, Entry{ListPosition: i, FilmID: id, SortVals: SortVals{"hue": rank}})
+	}
+	return letterboxdtest.NewServer(fakeList), list
+}
+
+// The order the list should end up in: sorted, then rotated by the offset and optionally reversed
+func (tc writeCase) expectedOrder(list ListWithEntries) []string {
+	entries := append([]Entry{}, list.Entries...)
+	sortFunction, _ := resolveSortFunction(tc.SortMethod)
+	sorted := orderEntries(entries, sortFunction, false)
+
+	n := len(sorted)
+	expected := make([]string, n)
+	for i, e := range sorted {
+		expected[sortedPosition(i, n, tc.Offset, tc.Reverse)] = e.FilmID
+	}
+	return expected
+}
+
+// For any list, offset and reverse flag, applying the update request as Letterboxd does gives the requested order
+func TestWriteListResultsInRequestedOrder(t *testing.T) {
+	property := func(tc writeCase) bool {
+		fake, list := tc.setup()
+		defer fake.Close()
+		expected := tc.expectedOrder(list)
+
+		preview, err := prepareListUpdateRequest(list, tc.Offset, tc.SortMethod, tc.Reverse)
+		if err != nil {
+			t.Logf("failed to prepare request: %v", err)
+			return false
+		}
+		_, err = writeListSorting(context.Background(), letterboxd.New(fake.URL, letterboxd.StaticToken(letterboxdtest.AccessToken)), list.ID, preview.Request)
+		if err != nil {
+			t.Logf("failed to write list: %v", err)
+			return false
+		}
+
+		if actual := fake.FilmIDs(list.ID); !reflect.DeepEqual(expected, actual) {
+			t.Logf("n=%d offset=%d reverse=%v\nexpected %v\nactual   %v", len(tc.Ranks), tc.Offset, tc.Reverse, expected, actual)
+			return false
+		}
+		return fake.Version(list.ID) == list.Version+1
+	}
+
+	if err := quick.Check(property, &quick.Config{MaxCount: 200}); err != nil {
+		t.Error(err)
+	}
+}
+
+// An update made against an outdated version of the list is rejected, and leaves the list untouched
+func TestWriteListVersionMismatch(t *testing.T) {
+	assert := assert.New(t)
+
+	tc := writeCase{Ranks: []int{3, 2, 1, 0}, SortMethod: "hue"}
+	fake, list := tc.setup()
+	defer fake.Close()
+	before := fake.FilmIDs(list.ID)
+
+	list.Version--
+	preview, err := prepareListUpdateRequest(list, 0, "hue", false)
+	assert.Nil(err)
+	_, err = writeListSorting(context.Background(), letterboxd.New(fake.URL, letterboxd.StaticToken(letterboxdtest.AccessToken)), list.ID, preview.Request)
+	assert.ErrorContains(err, letterboxdtest.CodeVersionMismatch)
+	assert.Equal(before, fake.FilmIDs(list.ID))
+}
+
+func TestLongestIncreasingSubsequence(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Empty(longestIncreasingSubsequence(nil))
+	assert.Equal([]bool{true, true, true}, longestIncreasingSubsequence([]int{0, 1, 2}))
+	assert.Equal([]bool{false, true, true, false, true}, longestIncreasingSubsequence([]int{4, 0, 1, 3, 2}))
+	assert.Equal(1, countTrue(longestIncreasingSubsequence([]int{3, 2, 1, 0})))
+}
+
+// Only the films out of order relative to the rest of the list are moved
+func TestCreateListUpdateEntriesMinimalMoves(t *testing.T) {
+	assert := assert.New(t)
+
+	// Plans the update for a list where the film with target position i is currently at current[i]
+	plan := func(current ...int) listUpdatePlan {
+		currentPositions := make(map[string]int)
+		var finish []FilmTargetPosition
+		for target, pos := range current {
+			id := fmt.Sprintf("film%d", target)
+			currentPositions[id] = pos
+			finish = append(finish, FilmTargetPosition{id, target})
+		}
+		return createListUpdateEntries(currentPositions, finish)
+	}
+
+	assert.Empty(plan(0, 1, 2, 3).Entries)
+
+	// The last film belongs first
+	p := plan(3, 0, 1, 2)
+	assert.Equal([]listUpdateEntry{{Action: "UPDATE", Position: 3, NewPosition: 0}}, p.Entries)
+	assert.Equal(0, p.MovesSaved)
+
+	// The first film belongs last: moving every other film up into place would take three moves
+	p = plan(1, 2, 3, 0)
+	assert.Equal([]listUpdateEntry{{Action: "UPDATE", Position: 0, NewPosition: 3}}, p.Entries)
+	assert.Equal(2, p.MovesSaved)
+}
+
+func countTrue(bs []bool) int {
+	count := 0
+	for _, b := range bs {
+		if b {
+			count++
+		}
+	}
+	return count
+}
@@ -7,15 +7,38 @@ import (
 	"time"
 
 	colorboxd "github.com/dsantos747/letterboxd_hue_sort/backend"
+	"github.com/dsantos747/letterboxd_hue_sort/backend/redis"
 )
 
 func main() {
+	colorboxd.LoadEnv() // Handlers report missing configuration themselves, on each request
+
+	rc, err := redis.New(os.Getenv("REDIS_URL"))
+	if err != nil {
+		log.Fatalf("Could not connect to redis: %v", err)
+	}
+
+	colorCache, err := colorboxd.NewColorCacheFromEnv(rc)
+	if err != nil {
+		log.Fatalf("Invalid colour cache configuration: %v", err)
+	}
+	h := colorboxd.NewHandlers(rc, colorCache)
+
 	mux := http.NewServeMux()
-	mux.HandleFunc("GET /api/v1/auth", colorboxd.AuthUser)
-	mux.HandleFunc("GET /api/v1/lists", colorboxd.GetLists)
-	mux.HandleFunc("GET /api/v1/sort", colorboxd.SortListById)
-	mux.HandleFunc("POST /api/v1/write", colorboxd.WriteList)
-	mux.HandleFunc("OPTIONS /api/v1/write", colorboxd.WriteList)
+	mux.HandleFunc("GET /api/v1/auth", h.AuthUser)
+	mux.HandleFunc("POST /api/v1/auth/refresh", h.RefreshAuth)
+	mux.HandleFunc("OPTIONS /api/v1/auth/refresh", h.RefreshAuth)
+	mux.HandleFunc("POST /api/v1/auth/signout", h.SignOut)
+	mux.HandleFunc("OPTIONS /api/v1/auth/signout", h.SignOut)
+	mux.HandleFunc("GET /api/v1/lists", h.GetLists)
+	mux.HandleFunc("GET /api/v1/sort", h.SortListById)
+	mux.HandleFunc("GET /api/v1/sorts", colorboxd.GetSorts)
+	mux.HandleFunc("POST /api/v1/write", h.WriteList)
+	mux.HandleFunc("OPTIONS /api/v1/write", h.WriteList)
+	mux.HandleFunc("POST /api/v1/write/resume", h.ResumeWrite)
+	mux.HandleFunc("OPTIONS /api/v1/write/resume", h.ResumeWrite)
+	mux.HandleFunc("POST /api/v1/write/undo", h.UndoWrite)
+	mux.HandleFunc("OPTIONS /api/v1/write/undo", h.UndoWrite)
 
 	port := "8080"
 	if envPort := os.Getenv("PORT"); envPort != "" {
@@ -0,0 +1,198 @@
+package colorboxd
+
+import (
+	"container/list"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dsantos747/letterboxd_hue_sort/backend/redis"
+)
+
+const defaultColorCacheSize = 10000 // Posters kept by the in-memory colour caches, unless COLOR_CACHE_SIZE says otherwise
+
+// ColorCache stores the dominant colours extracted from each poster, keyed by Entry.CacheKey
+type ColorCache interface {
+	GetBatch(keys []string) (map[string]redis.CacheResponse, error)
+	SetBatch(keys []string, values []redis.ColorValue) error
+	Get(key string) (redis.CacheResponse, error)
+	Set(key string, value redis.ColorValue) error
+}
+
+// NewColorCacheFromEnv creates the colour cache named by COLOR_CACHE: "redis" (the default) caches colours in
+// rc, "memory" in an in-memory LRU of COLOR_CACHE_SIZE posters, and "tiered" in an in-memory LRU in front of rc.
+func NewColorCacheFromEnv(rc redis.Redis) (ColorCache, error) {
+	size := defaultColorCacheSize
+	if sizeStr := os.Getenv("COLOR_CACHE_SIZE"); sizeStr != "" {
+		var err error
+		size, err = strconv.Atoi(sizeStr)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("COLOR_CACHE_SIZE must be a positive integer, got %q", sizeStr)
+		}
+	}
+
+	switch kind := os.Getenv("COLOR_CACHE"); kind {
+	case "", "redis":
+		return rc, nil
+	case "memory":
+		return newMemoryCache(size), nil
+	case "tiered":
+		return newTieredCache(newMemoryCache(size), rc), nil
+	default:
+		return nil, fmt.Errorf("unknown COLOR_CACHE %q; expected redis, memory or tiered", kind)
+	}
+}
+
+// memoryCache is a ColorCache holding the most recently used posters in memory, for local development and
+// tests, and to sit in front of redis in a tieredCache.
+type memoryCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // Most recently used at the front
+	entries map[string]*list.Element
+}
+
+type memoryCacheEntry struct {
+	key   string
+	value redis.ColorValue
+}
+
+func newMemoryCache(size int) *memoryCache {
+	return &memoryCache{size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (m *memoryCache) GetBatch(keys []string) (map[string]redis.CacheResponse, error) {
+	res := make(map[string]redis.CacheResponse)
+	for _, key := range keys {
+		res[key], _ = m.Get(key)
+	}
+	return res, nil
+}
+
+func (m *memoryCache) SetBatch(keys []string, values []redis.ColorValue) error {
+	if len(keys) != len(values) {
+		return fmt.Errorf("length of keys and values do not match")
+	}
+	for i, key := range keys {
+		m.Set(key, values[i])
+	}
+	return nil
+}
+
+func (m *memoryCache) Get(key string) (redis.CacheResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	elem, ok := m.entries[key]
+	if !ok {
+		return redis.CacheResponse{Hit: false}, nil
+	}
+	m.order.MoveToFront(elem)
+
+	return redis.CacheResponse{ColorValue: cloneColorValue(elem.Value.(*memoryCacheEntry).value), Hit: true}, nil
+}
+
+// Stores a copy of value, stamped with the current time if it has no CachedAt, as redis does
+func (m *memoryCache) Set(key string, value redis.ColorValue) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	value = cloneColorValue(value)
+	if value.CachedAt.IsZero() {
+		value.CachedAt = time.Now()
+	}
+
+	entry := &memoryCacheEntry{key: key, value: value}
+	if elem, ok := m.entries[key]; ok {
+		elem.Value = entry
+		m.order.MoveToFront(elem)
+		return nil
+	}
+
+	m.entries[key] = m.order.PushFront(entry)
+	if m.order.Len() > m.size {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(*memoryCacheEntry).key)
+	}
+	return nil
+}
+
+// tieredCache is a ColorCache that serves what it can from a local cache, and falls back to a shared
+// remote cache (redis) for the rest. Colours found remotely are kept locally for next time.
+type tieredCache struct {
+	local  ColorCache
+	remote ColorCache
+}
+
+func newTieredCache(local, remote ColorCache) *tieredCache {
+	return &tieredCache{local: local, remote: remote}
+}
+
+func (t *tieredCache) GetBatch(keys []string) (map[string]redis.CacheResponse, error) {
+	res, err := t.local.GetBatch(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for _, key := range keys {
+		if !res[key].Hit {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) == 0 {
+		return res, nil
+	}
+
+	// The local hits are still worth serving if the remote cache can't be reached
+	remoteRes, err := t.remote.GetBatch(missing)
+	if err != nil {
+		slog.Default().Warn("failed to read colours from the remote cache; serving local hits only", "err", err)
+		return res, nil
+	}
+	for _, key := range missing {
+		if hit := remoteRes[key]; hit.Hit {
+			t.local.Set(key, hit.ColorValue)
+			res[key] = hit
+		}
+	}
+	return res, nil
+}
+
+func (t *tieredCache) SetBatch(keys []string, values []redis.ColorValue) error {
+	if err := t.local.SetBatch(keys, values); err != nil {
+		return err
+	}
+	return t.remote.SetBatch(keys, values)
+}
+
+func (t *tieredCache) Get(key string) (redis.CacheResponse, error) {
+	res, err := t.local.Get(key)
+	if err != nil || res.Hit {
+		return res, err
+	}
+
+	res, err = t.remote.Get(key)
+	if err == nil && res.Hit {
+		t.local.Set(key, res.ColorValue)
+	}
+	return res, err
+}
+
+func (t *tieredCache) Set(key string, value redis.ColorValue) error {
+	if err := t.local.Set(key, value); err != nil {
+		return err
+	}
+	return t.remote.Set(key, value)
+}
+
+func cloneColorValue(value redis.ColorValue) redis.ColorValue {
+	value.Colors = slices.Clone(value.Colors)
+	value.Counts = slices.Clone(value.Counts)
+	return value
+}
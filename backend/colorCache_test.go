@@ -0,0 +1,211 @@
+package colorboxd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dsantos747/letterboxd_hue_sort/backend/letterboxd/letterboxdtest"
+	"github.com/dsantos747/letterboxd_hue_sort/backend/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	assert := assert.New(t)
+	cache := newMemoryCache(2)
+
+	assert.Nil(cache.Set("a_1", redis.ColorValue{Colors: []string{"#FF0000"}, Counts: []int{10}}))
+	assert.Nil(cache.Set("b_1", redis.ColorValue{Colors: []string{"#00FF00"}, Counts: []int{20}}))
+
+	// Reading a makes b the least recently used, so b is evicted to make room for c
+	res, err := cache.Get("a_1")
+	assert.Nil(err)
+	assert.True(res.Hit)
+	assert.Equal([]string{"#FF0000"}, res.Colors)
+	assert.Equal([]int{10}, res.Counts)
+	assert.WithinDuration(time.Now(), res.CachedAt, time.Minute)
+	assert.Nil(cache.SetBatch([]string{"c_1"}, []redis.ColorValue{{Colors: []string{"#0000FF"}, Counts: []int{30}}}))
+
+	batch, err := cache.GetBatch([]string{"a_1", "b_1", "c_1"})
+	assert.Nil(err)
+	assert.True(batch["a_1"].Hit)
+	assert.False(batch["b_1"].Hit)
+	assert.True(batch["c_1"].Hit)
+
+	// Overwriting a key doesn't take up more room
+	assert.Nil(cache.Set("c_1", redis.ColorValue{Colors: []string{"#FFFFFF"}, Counts: []int{40}}))
+	res, _ = cache.Get("c_1")
+	assert.Equal([]string{"#FFFFFF"}, res.Colors)
+	res, _ = cache.Get("a_1")
+	assert.True(res.Hit)
+
+	assert.Error(cache.SetBatch([]string{"d_1"}, nil))
+}
+
+func TestMemoryCacheCopiesValues(t *testing.T) {
+	assert := assert.New(t)
+	cache := newMemoryCache(1)
+
+	colors := []string{"#FF0000"}
+	cache.Set("a_1", redis.ColorValue{Colors: colors, Counts: []int{10}})
+	colors[0] = "#000000"
+
+	res, _ := cache.Get("a_1")
+	res.Colors[0] = "#FFFFFF"
+
+	res, _ = cache.Get("a_1")
+	assert.Equal([]string{"#FF0000"}, res.Colors)
+}
+
+func TestTieredCache(t *testing.T) {
+	assert := assert.New(t)
+	s := setTestEnv(t, "")
+	remote := newTestRedis(t)
+	local := newMemoryCache(10)
+	cache := newTieredCache(local, remote)
+
+	// Writes go to both tiers
+	assert.Nil(cache.Set("a_1", redis.ColorValue{Colors: []string{"#FF0000"}, Counts: []int{10}}))
+	assert.True(s.Exists("a_1"))
+	res, _ := local.Get("a_1")
+	assert.True(res.Hit)
+
+	// Reads missing locally are served from redis, and kept locally
+	assert.Nil(remote.Set("b_1", redis.ColorValue{Colors: []string{"#00FF00"}, Counts: []int{20}}))
+	batch, err := cache.GetBatch([]string{"a_1", "b_1", "c_1"})
+	assert.Nil(err)
+	assert.True(batch["b_1"].Hit)
+	assert.Equal([]string{"#00FF00"}, batch["b_1"].Colors)
+	assert.False(batch["c_1"].Hit)
+	res, _ = local.Get("b_1")
+	assert.True(res.Hit)
+
+	// Once kept locally, reads don't need redis
+	s.Close()
+	res, err = cache.Get("b_1")
+	assert.Nil(err)
+	assert.True(res.Hit)
+	_, err = cache.Get("c_1")
+	assert.Error(err)
+
+	// And are still served in a batch that redis can't complete
+	batch, err = cache.GetBatch([]string{"a_1", "b_1", "c_1"})
+	assert.Nil(err)
+	assert.True(batch["a_1"].Hit)
+	assert.True(batch["b_1"].Hit)
+	assert.False(batch["c_1"].Hit)
+}
+
+func TestNewColorCacheFromEnv(t *testing.T) {
+	assert := assert.New(t)
+	setTestEnv(t, "")
+	rc := newTestRedis(t)
+
+	testCases := []struct {
+		kind     string
+		size     string
+		expected ColorCache
+		errStr   string
+	}{
+		{kind: "", expected: redis.Redis{}},
+		{kind: "redis", expected: redis.Redis{}},
+		{kind: "memory", expected: &memoryCache{}},
+		{kind: "memory", size: "5", expected: &memoryCache{}},
+		{kind: "tiered", expected: &tieredCache{}},
+		{kind: "memcached", errStr: `unknown COLOR_CACHE "memcached"`},
+		{kind: "memory", size: "0", errStr: "COLOR_CACHE_SIZE must be a positive integer"},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s/%s", tc.kind, tc.size), func(t *testing.T) {
+			t.Setenv("COLOR_CACHE", tc.kind)
+			t.Setenv("COLOR_CACHE_SIZE", tc.size)
+
+			cache, err := NewColorCacheFromEnv(rc)
+			if tc.errStr != "" {
+				assert.ErrorContains(err, tc.errStr)
+				return
+			}
+			assert.Nil(err)
+			assert.IsType(tc.expected, cache)
+		})
+	}
+}
+
+// With an in-memory colour cache, sorting caches colours in memory rather than redis
+func TestSortListUsesColorCache(t *testing.T) {
+	assert := assert.New(t)
+
+	fake := letterboxdtest.NewServer(letterboxdtest.GradientList("tqtA2", 5))
+	defer fake.Close()
+	s := setTestEnv(t, fake.URL)
+	cache := newMemoryCache(10)
+	h := NewHandlers(newTestRedis(t), cache)
+
+	cookie := signIn(t, h)
+	w := serve(h.SortListById, httptest.NewRequest("GET", "/api/v1/sort?listId=tqtA2", nil), cookie)
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+
+	assert.Eventually(func() bool {
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		return cache.order.Len() == 5
+	}, time.Second, 10*time.Millisecond) // The colours are cached in the background
+	for _, key := range s.Keys() {
+		assert.True(strings.HasPrefix(key, "session:"), key)
+	}
+	for _, elem := range cache.entries {
+		assert.Equal(colorExtractionAlgorithm, elem.Value.(*memoryCacheEntry).value.Algorithm)
+	}
+}
+
+// If the colour cache's redis can't be reached, sorting carries on without the cache
+func TestSortListWithoutColorCache(t *testing.T) {
+	assert := assert.New(t)
+
+	fake := letterboxdtest.NewServer(letterboxdtest.GradientList("tqtA2", 5))
+	defer fake.Close()
+	setTestEnv(t, fake.URL)
+
+	down := miniredis.RunT(t)
+	cache, err := redis.New(fmt.Sprintf("redis://%s", down.Addr()))
+	assert.Nil(err)
+	down.Close()
+	h := NewHandlers(newTestRedis(t), cache)
+
+	cookie := signIn(t, h)
+	w := serve(h.SortListById, httptest.NewRequest("GET", "/api/v1/sort?listId=tqtA2", nil), cookie)
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	var sorted map[string][]Entry
+	assert.Nil(json.NewDecoder(w.Body).Decode(&sorted))
+	assert.Len(sorted["items"], 5)
+}
+
+// With sessions and colours in the same redis, as in production, signed-in users can still sort lists
+// (without the colour cache) while redis is down
+func TestSortListWhileRedisDown(t *testing.T) {
+	assert := assert.New(t)
+
+	fake := letterboxdtest.NewServer(letterboxdtest.GradientList("tqtA2", 5))
+	defer fake.Close()
+	s := setTestEnv(t, fake.URL)
+	h := newTestHandlers(t)
+
+	cookie := signIn(t, h)
+	s.Close()
+
+	w := serve(h.SortListById, httptest.NewRequest("GET", "/api/v1/sort?listId=tqtA2", nil), cookie)
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	var sorted map[string][]Entry
+	assert.Nil(json.NewDecoder(w.Body).Decode(&sorted))
+	assert.Len(sorted["items"], 5)
+
+	// Sessions that weren't in use before redis went down can't be found
+	other := &http.Cookie{Name: sessionCookieName, Value: "unknown"}
+	w = serve(h.SortListById, httptest.NewRequest("GET", "/api/v1/sort?listId=tqtA2", nil), other)
+	assert.Equal(http.StatusInternalServerError, w.Code, w.Body.String())
+}
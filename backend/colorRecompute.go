@@ -0,0 +1,79 @@
+package colorboxd
+
+import (
+	"log/slog"
+	"sync"
+
+	"github.com/dsantos747/letterboxd_hue_sort/backend/redis"
+)
+
+const maxConcurrentRecomputes = 10 // Kept low, as recomputing competes with requests for Letterboxd's image servers
+
+// Cached colours extracted by an older version of the pipeline (see colorExtractionAlgorithm) are still served,
+// so that changes to the extraction can be rolled out without flushing the cache. They are recomputed in the
+// background, so that later requests get the new colours.
+var recomputer = newColorRecomputer(maxConcurrentRecomputes)
+
+type colorRecomputer struct {
+	mu       sync.Mutex
+	inFlight map[string]bool // Cache keys being recomputed, so that each poster is only recomputed once at a time
+	sem      chan struct{}
+	wg       sync.WaitGroup
+}
+
+func newColorRecomputer(concurrency int) *colorRecomputer {
+	return &colorRecomputer{inFlight: make(map[string]bool), sem: make(chan struct{}, concurrency)}
+}
+
+// Whether cached colours need recomputing with the current extraction pipeline
+func isStale(res redis.CacheResponse) bool {
+	return res.Algorithm != colorExtractionAlgorithm
+}
+
+// Recomputes the colours of the given entries in the background, storing them in cache. Entries already
+// being recomputed are skipped.
+func (r *colorRecomputer) enqueue(cache ColorCache, entries []Entry) {
+	for _, entry := range entries {
+		r.mu.Lock()
+		if r.inFlight[entry.CacheKey] {
+			r.mu.Unlock()
+			continue
+		}
+		r.inFlight[entry.CacheKey] = true
+		r.mu.Unlock()
+
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			defer func() {
+				r.mu.Lock()
+				delete(r.inFlight, entry.CacheKey)
+				r.mu.Unlock()
+			}()
+
+			r.sem <- struct{}{}
+			defer func() { <-r.sem }()
+
+			if err := recomputeColors(cache, entry); err != nil {
+				slog.Default().Warn("failed to recompute poster colours", "key", entry.CacheKey, "err", err)
+			}
+		}()
+	}
+}
+
+// Waits for every recompute enqueued so far to finish
+func (r *colorRecomputer) wait() {
+	r.wg.Wait()
+}
+
+func recomputeColors(cache ColorCache, entry Entry) error {
+	img, err := loadImage(entry.ImageInfo.Path)
+	if err != nil {
+		return err
+	}
+	updated, err := getImageInfo(entry, img)
+	if err != nil {
+		return err
+	}
+	return cache.Set(entry.CacheKey, colorValue(updated.ImageInfo.Colors))
+}
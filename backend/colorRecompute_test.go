@@ -0,0 +1,74 @@
+package colorboxd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dsantos747/letterboxd_hue_sort/backend/letterboxd/letterboxdtest"
+	"github.com/dsantos747/letterboxd_hue_sort/backend/redis"
+	"github.com/lucasb-eyer/go-colorful"
+	"github.com/stretchr/testify/assert"
+)
+
+// Colours cached by an older extraction pipeline are served until they have been recomputed in the background
+func TestStaleColorsRecomputed(t *testing.T) {
+	assert := assert.New(t)
+
+	fake := letterboxdtest.NewServer(letterboxdtest.GradientList("tqtA2", 6))
+	defer fake.Close()
+	setTestEnv(t, fake.URL)
+	cache := newMemoryCache(10)
+	h := NewHandlers(newTestRedis(t), cache)
+
+	cookie := signIn(t, h)
+	sort := func() string {
+		w := serve(h.SortListById, httptest.NewRequest("GET", "/api/v1/sort?listId=tqtA2&sortMethod=hue", nil), cookie)
+		assert.Equal(http.StatusOK, w.Code, w.Body.String())
+		return w.Body.String()
+	}
+
+	fresh := sort()
+	assert.Eventually(func() bool {
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		return cache.order.Len() == 6
+	}, time.Second, 10*time.Millisecond) // The colours are cached in the background
+
+	// Replace every cached value with one from an older pipeline, which puts the posters in reverse order
+	cache.mu.Lock()
+	var keys []string
+	for key := range cache.entries {
+		keys = append(keys, key)
+	}
+	cache.mu.Unlock()
+	assert.Len(keys, 6)
+	for _, key := range keys {
+		res, _ := cache.Get(key)
+		color, _ := colorful.Hex(res.Colors[0])
+		h, s, l := color.Hsl()
+		reversed := colorful.Hsl(359-h, s, l).Hex()
+		stale := redis.ColorValue{Colors: []string{reversed}, Counts: []int{100}, Algorithm: "prominentcolor-kmeans-k3-nocrop/v0"}
+		assert.Nil(cache.Set(key, stale))
+	}
+
+	// The stale colours are served while they are recomputed
+	assert.NotEqual(fresh, sort())
+	recomputer.wait()
+
+	for _, key := range keys {
+		res, _ := cache.Get(key)
+		assert.Equal(colorExtractionAlgorithm, res.Algorithm)
+	}
+	assert.Equal(fresh, sort())
+}
+
+// Legacy values carry no algorithm, so are recomputed too
+func TestIsStale(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.False(isStale(redis.CacheResponse{ColorValue: redis.ColorValue{Algorithm: colorExtractionAlgorithm}, Hit: true}))
+	assert.True(isStale(redis.CacheResponse{ColorValue: redis.ColorValue{Algorithm: "prominentcolor-kmeans-k3-nocrop/v0"}, Hit: true}))
+	assert.True(isStale(redis.CacheResponse{Hit: true}))
+}
@@ -3,87 +3,35 @@ package colorboxd
 import (
 	"image"
 
+	"github.com/dsantos747/letterboxd_hue_sort/backend/letterboxd"
+
 	"github.com/lucasb-eyer/go-colorful"
 )
 
-// The response format from Letterboxd auth/token endpoint
-type AccessTokenResponse struct {
-	AccessToken  string `json:"access_token"`
-	TokenType    string `json:"token_type"`
-	RefreshToken string `json:"refresh_token"`
-	ExpiresIn    int    `json:"expires_in"`
-	NotBefore    int    `json:"notBefore"`
-	Issuer       string `json:"issuer"`
-	EncodedToken string `json:"encodedToken"`
-}
-
-// The response format from Letterboxd /me endpoint
-type Member struct {
-	ID          string `json:"id"`
-	DisplayName string `json:"displayName"`
-	GivenName   string `json:"givenName"`
-	Username    string `json:"username"`
-}
+// Letterboxd API types, defined in the letterboxd package
+type (
+	AccessTokenResponse = letterboxd.AccessTokenResponse
+	Member              = letterboxd.Member
+	ListsResponse       = letterboxd.ListsResponse
+	ListSummary         = letterboxd.ListSummary
+	List                = letterboxd.List
+	ListEntriesResponse = letterboxd.ListEntriesResponse
+	ListEntries         = letterboxd.ListEntries
+	ListUpdateRequest   = letterboxd.ListUpdateRequest
+	listUpdateEntry     = letterboxd.ListUpdateEntry
+	ListUpdateResponse  = letterboxd.ListUpdateResponse
+	ListUpdateMessage   = letterboxd.ListUpdateMessage
+)
 
-// HTTPAuthUser responds to the client with this format
+// HTTPAuthUser responds to the client with this format. The tokens themselves stay in the server-side session.
 type AuthUserResponse struct {
-	Token          string
 	TokenType      string
 	TokenExpiresIn int
-	TokenRefresh   string
 	UserId         string
 	Username       string
 	UserGivenName  string
 }
 
-// The response format from Letterboxd /lists endpoint
-type ListsResponse struct {
-	Cursor string        `json:"cursor"` // Keep this cursor around - need it to handle cases where user has over 100 lists (king)
-	Items  []ListSummary `json:"items"`
-}
-
-// Summary information about a list. In our case, one of the user's lists
-type ListSummary struct {
-	ID          string `json:"id"`
-	Name        string `json:"name"`
-	Version     int    `json:"version"`
-	FilmCount   int    `json:"filmCount"`
-	Description string `json:"description"`
-}
-
-// The (partial) response format from Letterboxd list/{id} endpoint
-type List struct {
-	ID        string `json:"id"`
-	FilmCount int32  `json:"filmCount"`
-}
-
-// The (partial) response format from Letterboxd list/{id}/entries endpoint
-type ListEntriesResponse struct {
-	Next  string        `json:"next"`
-	Items []ListEntries `json:"items"`
-}
-type ListEntries struct {
-	EntryID string `json:"entryId"`
-	Film    film   `json:"film"`
-}
-type film struct {
-	Adult              bool     `json:"adult"`
-	ID                 string   `json:"id"`
-	Name               string   `json:"name"`
-	Poster             coverImg `json:"poster"`
-	AdultPoster        coverImg `json:"adultPoster"`
-	PosterCustomisable bool     `json:"posterCustomisable"`
-	ReleaseYear        int      `json:"releaseYear"`
-}
-type coverImg struct {
-	Sizes []imgSize `json:"sizes"`
-}
-type imgSize struct {
-	Height int    `json:"height"`
-	URL    string `json:"url"`
-	Width  int    `json:"width"`
-}
-
 // Entry is the output format of GetListEntries
 type Entry struct {
 	ListPosition       int    // position in the list - not returned by API
@@ -102,18 +50,8 @@ type Entry struct {
 	Hex2               string   `json:"hex2"`
 }
 
-// All possible sort algorithms. Used in a reflect
-type SortVals struct {
-	Hue             int `json:"hue"`
-	Lum             int `json:"lum"`
-	BrightDomHue    int `json:"brightDomHue"`
-	InverseStep_8   int `json:"inverseStep_8"`
-	InverseStep_12  int `json:"inverseStep_12"`
-	InverseStep2_8  int `json:"inverseStep2_8"`
-	InverseStep2_12 int `json:"inverseStep2_12"`
-	BRBW1           int `json:"BRBW1"`
-	BRBW2           int `json:"BRBW2"`
-}
+// A poster's rank under each registered sort algorithm, keyed by algorithm name (see sortRegistry.go)
+type SortVals map[string]int
 
 // An images path and colour information
 type ImageInfo struct {
@@ -135,38 +73,40 @@ type Image struct {
 
 // This is the format of the request body for HTTPWriteList
 type WriteListRequest struct {
-	AccessToken string          `json:"accessToken"`
-	List        ListWithEntries `json:"list"` // This being ListWithEntries (rather than any) is what is causing the error
-	Offset      int             `json:"offset"`
-	SortMethod  string          `json:"sortMethod"`
-	Reverse     bool            `json:"reverse"`
-}
-type ListWithEntries struct {
-	ListSummary
-	Entries []Entry `json:"entries"`
+	ListID     string `json:"listId"`
+	Offset     int    `json:"offset"`
+	SortMethod string `json:"sortMethod"`
+	Reverse    bool   `json:"reverse"`
+	DryRun     bool   `json:"dryRun"` // Return what the write would do, without making it
+}
+
+// HTTPWriteList responds with this format for a dry run
+type WritePreviewResponse struct {
+	Request    ListUpdateRequest   `json:"request"`
+	Order      []WritePreviewEntry `json:"order"` // Every film in the list, in its new order
+	Moves      int                 `json:"moves"`
+	MovesSaved int                 `json:"movesSaved"` // How many fewer moves this needs than moving each film into place from the top of the list
+}
+type WritePreviewEntry struct {
+	FilmID      string `json:"filmId"`
+	Name        string `json:"name"`
+	OldPosition int    `json:"oldPosition"`
+	NewPosition int    `json:"newPosition"`
 }
 
-// This is the required format for making a PATCH request to letterboxd list/{id} endpoint.
-//
-// Note: This struct only includes parameters we are interested in controlling/modifying
-type ListUpdateRequest struct {
-	Version int               `json:"version"`
-	Entries []listUpdateEntry `json:"entries"`
-}
-type listUpdateEntry struct {
-	Action      string `json:"action"`
-	Position    int    `json:"position"`
-	NewPosition int    `json:"newPosition"`
+// This is the format of the request body for HTTPResumeWrite
+type ResumeWriteRequest struct {
+	ListID string `json:"listId"`
 }
 
-// This is the response format from a PATCH request to the letterboxd list/{id} endpoint.
-type ListUpdateResponse struct {
-	Messages []ListUpdateMessage `json:"messages"`
+// This is the format of the request body for HTTPUndoWrite
+type UndoWriteRequest struct {
+	ListID string `json:"listId"`
 }
-type ListUpdateMessage struct {
-	Type  string `json:"type"`
-	Code  string `json:"code"`
-	Title string `json:"title"`
+
+type ListWithEntries struct {
+	ListSummary
+	Entries []Entry `json:"entries"`
 }
 
 type FilmTargetPosition struct {
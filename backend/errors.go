@@ -0,0 +1,128 @@
+package colorboxd
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dsantos747/letterboxd_hue_sort/backend/letterboxd"
+)
+
+// Error codes sent to the client. These are stable, so that the frontend can react to specific failures.
+const (
+	ErrCodeBadRequest             = "bad_request"
+	ErrCodeUnsupportedMediaType   = "unsupported_media_type"
+	ErrCodeForbidden              = "forbidden"               // The request came from a site other than the frontend
+	ErrCodeUnauthorized           = "unauthorized"            // The request has no valid colorboxd session
+	ErrCodeLetterboxdUnauthorized = "letterboxd_unauthorized" // Letterboxd rejected the user's authorisation
+	ErrCodeRateLimited            = "rate_limited"
+	ErrCodeListNotFound           = "list_not_found"
+	ErrCodeNotFound               = "not_found"
+	ErrCodePosterFetchFailed      = "poster_fetch_failed"
+	ErrCodePosterDecodeFailed     = "poster_decode_failed"
+	ErrCodeListVersionConflict    = "list_version_conflict"
+	ErrCodeLetterboxdError        = "letterboxd_error" // Letterboxd failed in some other way
+	ErrCodeInternal               = "internal_error"
+)
+
+// APIError is an error to respond to the client with. Code and Message are sent to the client; the
+// underlying error is only logged, so that upstream and internal details are never leaked.
+type APIError struct {
+	Status     int
+	Code       string
+	Message    string
+	Err        error
+	RetryAfter time.Duration // Sent to rate-limited clients, so they know when to try again
+}
+
+func newAPIError(status int, code, message string, err error) *APIError {
+	return &APIError{Status: status, Code: code, Message: message, Err: err}
+}
+
+func badRequest(message string) *APIError {
+	return newAPIError(http.StatusBadRequest, ErrCodeBadRequest, message, nil)
+}
+
+// Gives err the status, code and message to respond with, unless it already has its own (see toAPIError)
+func apiErrorOr(err error, status int, code, message string) *APIError {
+	if apiErr := toAPIError(err); apiErr.Code != ErrCodeInternal {
+		return apiErr
+	}
+	return newAPIError(status, code, message, err)
+}
+
+func (e *APIError) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+	return e.Message + ": " + e.Err.Error()
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// Every handler responds to errors with this format
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+type ErrorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Converts any error into the APIError to respond with. An APIError anywhere in the chain of wrapped errors
+// is used as is; otherwise known errors are given their status and code, and anything else is internal.
+func toAPIError(err error) *APIError {
+	var apiErr *APIError
+	var upstreamErr *letterboxd.UpstreamError
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr
+	case errors.As(err, &upstreamErr):
+		return upstreamAPIError(upstreamErr, err)
+	case errors.Is(err, errNoSession):
+		return newAPIError(http.StatusUnauthorized, ErrCodeUnauthorized, errNoSession.Error(), err)
+	case errors.Is(err, errListVersionConflict):
+		return newAPIError(http.StatusConflict, ErrCodeListVersionConflict, "the list has changed on Letterboxd; please reload it and try again", err)
+	default:
+		return newAPIError(http.StatusInternalServerError, ErrCodeInternal, "something went wrong", err)
+	}
+}
+
+// Maps an error status from Letterboxd onto the status to respond with. Letterboxd's own description of
+// the error is not passed on.
+func upstreamAPIError(upstreamErr *letterboxd.UpstreamError, err error) *APIError {
+	switch upstreamErr.StatusCode {
+	case http.StatusUnauthorized:
+		return newAPIError(http.StatusUnauthorized, ErrCodeLetterboxdUnauthorized, "Letterboxd rejected the authorisation; please sign in again", err)
+	case http.StatusNotFound:
+		return newAPIError(http.StatusNotFound, ErrCodeNotFound, "not found on Letterboxd", err)
+	case http.StatusTooManyRequests:
+		apiErr := newAPIError(http.StatusTooManyRequests, ErrCodeRateLimited, "Letterboxd is limiting requests; please try again later", err)
+		apiErr.RetryAfter = upstreamErr.RetryAfter
+		return apiErr
+	default:
+		return newAPIError(http.StatusBadGateway, ErrCodeLetterboxdError, "Letterboxd responded with an error", err)
+	}
+}
+
+// ReturnError sends err back to the ResponseWriter w as a JSON ErrorResponse
+func ReturnError(w http.ResponseWriter, err error) {
+	apiErr := toAPIError(err)
+	if apiErr.Status >= 500 {
+		slog.Default().Error(apiErr.Message, "code", apiErr.Code, "err", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if apiErr.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(apiErr.RetryAfter.Seconds()))))
+	}
+	w.WriteHeader(apiErr.Status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: ErrorBody{Code: apiErr.Code, Message: apiErr.Message}})
+}
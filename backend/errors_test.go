@@ -0,0 +1,95 @@
+package colorboxd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dsantos747/letterboxd_hue_sort/backend/letterboxd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToAPIError(t *testing.T) {
+	assert := assert.New(t)
+
+	notFound := newAPIError(http.StatusNotFound, ErrCodeListNotFound, "list not found", errors.New("404 Not Found"))
+	assert.Equal(notFound, toAPIError(fmt.Errorf("failed to load list: %w", notFound)))
+
+	assert.Equal(http.StatusUnauthorized, toAPIError(fmt.Errorf("wrapped: %w", errNoSession)).Status)
+	assert.Equal(ErrCodeListVersionConflict, toAPIError(fmt.Errorf("wrapped: %w", errListVersionConflict)).Code)
+	assert.Equal(ErrCodeInternal, toAPIError(errors.New("unexpected")).Code)
+
+	// A known error keeps its own code, while anything else takes the one given
+	assert.Equal(ErrCodeListNotFound, apiErrorOr(notFound, http.StatusBadGateway, ErrCodeLetterboxdError, "failed").Code)
+	apiErr := apiErrorOr(errors.New("unexpected"), http.StatusBadGateway, ErrCodeLetterboxdError, "failed")
+	assert.Equal(http.StatusBadGateway, apiErr.Status)
+	assert.Equal(ErrCodeLetterboxdError, apiErr.Code)
+	assert.Equal("failed", apiErr.Message)
+}
+
+// Error responses carry the code and message, but never the underlying error
+func TestReturnError(t *testing.T) {
+	assert := assert.New(t)
+
+	w := httptest.NewRecorder()
+	ReturnError(w, newAPIError(http.StatusBadGateway, ErrCodeLetterboxdError, "couldn't update user list", errors.New("secret upstream details")))
+
+	assert.Equal(http.StatusBadGateway, w.Code)
+	assert.Equal("application/json", w.Header().Get("Content-Type"))
+	assert.NotContains(w.Body.String(), "secret")
+
+	var res ErrorResponse
+	assert.Nil(json.NewDecoder(w.Body).Decode(&res))
+	assert.Equal(ErrorBody{Code: ErrCodeLetterboxdError, Message: "couldn't update user list"}, res.Error)
+}
+
+// Error statuses from Letterboxd are passed on to the client as the matching status and code
+func TestUpstreamErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(status)
+		w.Write([]byte(`{"code":"Limited","message":"slow down"}`))
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		upstream int
+		status   int
+		code     string
+	}{
+		{http.StatusUnauthorized, http.StatusUnauthorized, ErrCodeLetterboxdUnauthorized},
+		{http.StatusNotFound, http.StatusNotFound, ErrCodeNotFound},
+		{http.StatusTooManyRequests, http.StatusTooManyRequests, ErrCodeRateLimited},
+		{http.StatusInternalServerError, http.StatusBadGateway, ErrCodeLetterboxdError},
+	} {
+		status = tc.upstream
+		_, err := MakeHTTPRequest("GET", srv.URL, nil, nil)
+
+		var upstreamErr *letterboxd.UpstreamError
+		assert.ErrorAs(err, &upstreamErr)
+		assert.Equal(tc.upstream, upstreamErr.StatusCode)
+		assert.Equal("slow down", upstreamErr.Payload.Message)
+		assert.Equal(30*time.Second, upstreamErr.RetryAfter)
+
+		w := httptest.NewRecorder()
+		ReturnError(w, apiErrorOr(fmt.Errorf("wrapped: %w", err), http.StatusInternalServerError, ErrCodeInternal, "failed"))
+		assert.Equal(tc.status, w.Code)
+		assert.NotContains(w.Body.String(), "slow down")
+
+		var res ErrorResponse
+		assert.Nil(json.NewDecoder(w.Body).Decode(&res))
+		assert.Equal(tc.code, res.Error.Code)
+		if tc.code == ErrCodeRateLimited {
+			assert.Equal("30", w.Header().Get("Retry-After"))
+		} else {
+			assert.Empty(w.Header().Get("Retry-After"))
+		}
+	}
+}
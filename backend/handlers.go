@@ -0,0 +1,16 @@
+package colorboxd
+
+import "github.com/dsantos747/letterboxd_hue_sort/backend/redis"
+
+// Handlers serves the API. Its dependencies are created once at startup (see cmd/main.go) and shared by
+// every request.
+type Handlers struct {
+	redis    redis.Redis // Holds the progress and snapshots of list writes
+	sessions SessionStore
+	colors   ColorCache
+}
+
+// NewHandlers creates the handlers. Sessions are stored in rc, and served from memory while it can't be reached.
+func NewHandlers(rc redis.Redis, colors ColorCache) *Handlers {
+	return &Handlers{redis: rc, sessions: newFallbackSessionStore(rc), colors: colors}
+}
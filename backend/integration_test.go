@@ -0,0 +1,443 @@
+package colorboxd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dsantos747/letterboxd_hue_sort/backend/letterboxd"
+	"github.com/dsantos747/letterboxd_hue_sort/backend/letterboxd/letterboxdtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// Serves a request through a handler, attaching the session cookie if present. POST bodies are sent as JSON,
+// as the frontend does, unless the request says otherwise.
+func serve(handler http.HandlerFunc, r *http.Request, cookie *http.Cookie) *httptest.ResponseRecorder {
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	if r.Method == "POST" && r.Header.Get("Content-Type") == "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// Creates the handlers, connected to the miniredis started by setTestEnv. Colours are cached in redis too.
+func newTestHandlers(t *testing.T) *Handlers {
+	rc := newTestRedis(t)
+	return NewHandlers(rc, rc)
+}
+
+// Signs in against the fake Letterboxd server, returning the session cookie
+func signIn(t *testing.T, h *Handlers) *http.Cookie {
+	w := serve(h.AuthUser, httptest.NewRequest("GET", "/api/v1/auth?authCode="+letterboxdtest.AuthCode, nil), nil)
+	if w.Code != http.StatusOK || len(w.Result().Cookies()) != 1 {
+		t.Fatalf("failed to sign in: %d %s", w.Code, w.Body.String())
+	}
+	return w.Result().Cookies()[0]
+}
+
+// Decodes the error code from an error response
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	var res ErrorResponse
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatalf("failed to decode error response: %v", err)
+	}
+	return res.Error.Code
+}
+
+// Walks through the whole flow the frontend uses: sign in, list the user's lists, sort one, and write it back
+func TestEndToEnd(t *testing.T) {
+	assert := assert.New(t)
+
+	fake := letterboxdtest.NewServer(letterboxdtest.GradientList("tqtA2", 44), letterboxdtest.GradientList("other", 3))
+	defer fake.Close()
+	setTestEnv(t, fake.URL)
+	h := newTestHandlers(t)
+
+	cookie := signIn(t, h)
+
+	// Lists
+	w := serve(h.GetLists, httptest.NewRequest("GET", "/api/v1/lists", nil), cookie)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("Cookie", w.Header().Get("Vary"))
+	var lists []ListSummary
+	assert.Nil(json.NewDecoder(w.Body).Decode(&lists))
+	assert.Len(lists, 2)
+	assert.Equal(ListSummary{ID: "tqtA2", Name: "Gradient tqtA2", Version: 1, FilmCount: 44}, lists[0])
+
+	// Sort
+	w = serve(h.SortListById, httptest.NewRequest("GET", "/api/v1/sort?listId=tqtA2", nil), cookie)
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	sortBody := w.Body.Bytes()
+	assert.Equal("Cookie", w.Header().Get("Vary"))
+	var sorted map[string][]Entry
+	assert.Nil(json.Unmarshal(sortBody, &sorted))
+	assert.Len(sorted["items"], 44)
+	for i := 1; i < len(sorted["items"]); i++ {
+		assert.LessOrEqual(sorted["items"][i-1].SortVals["hue"], sorted["items"][i].SortVals["hue"])
+	}
+
+	// Sorting again gives an identical response, whether or not the colours are now served from the cache
+	w = serve(h.SortListById, httptest.NewRequest("GET", "/api/v1/sort?listId=tqtA2", nil), cookie)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(string(sortBody), w.Body.String())
+
+	// A dry run previews the write without touching the list
+	body, _ := json.Marshal(WriteListRequest{ListID: "tqtA2", SortMethod: "hue", DryRun: true})
+	w = serve(h.WriteList, httptest.NewRequest("POST", "/api/v1/write", bytes.NewReader(body)), cookie)
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	var preview WritePreviewResponse
+	assert.Nil(json.NewDecoder(w.Body).Decode(&preview))
+	assert.Equal(1, preview.Request.Version)
+	assert.Equal(len(preview.Request.Entries), preview.Moves)
+	assert.NotZero(preview.Moves)
+	var positions []int // Each film's current position, in its new order
+	for _, e := range preview.Order {
+		positions = append(positions, e.OldPosition)
+	}
+	assert.Equal(topDownMoveCount(positions)-preview.Moves, preview.MovesSaved)
+	assert.Len(preview.Order, 44)
+	for i, e := range preview.Order {
+		assert.Equal(sorted["items"][i].FilmID, e.FilmID)
+		assert.Equal(sorted["items"][i].Name, e.Name)
+		assert.Equal(sorted["items"][i].ListPosition, e.OldPosition)
+		assert.Equal(i, e.NewPosition)
+	}
+	assert.Empty(fake.Patches("tqtA2"))
+
+	// Write
+	body, _ = json.Marshal(WriteListRequest{ListID: "tqtA2", SortMethod: "hue"})
+	w = serve(h.WriteList, httptest.NewRequest("POST", "/api/v1/write", bytes.NewReader(body)), cookie)
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	patches := fake.Patches("tqtA2")
+	assert.Len(patches, 1)
+	assert.Equal(1, patches[0].Version)
+	assert.NotEmpty(patches[0].Entries)
+}
+
+func TestEndToEndErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	fake := letterboxdtest.NewServer(letterboxdtest.GradientList("tqtA2", 5))
+	defer fake.Close()
+	setTestEnv(t, fake.URL)
+	h := newTestHandlers(t)
+
+	w := serve(h.AuthUser, httptest.NewRequest("GET", "/api/v1/auth?authCode=wrong", nil), nil)
+	assert.NotEqual(http.StatusOK, w.Code)
+	assert.Equal("application/json", w.Header().Get("Content-Type"))
+
+	// Without a session, nothing is reachable
+	w = serve(h.GetLists, httptest.NewRequest("GET", "/api/v1/lists", nil), nil)
+	assert.Equal(http.StatusUnauthorized, w.Code)
+	assert.Equal(ErrCodeUnauthorized, errorCode(t, w))
+	w = serve(h.SortListById, httptest.NewRequest("GET", "/api/v1/sort?listId=tqtA2", nil), nil)
+	assert.Equal(http.StatusUnauthorized, w.Code)
+
+	cookie := signIn(t, h)
+	w = serve(h.SortListById, httptest.NewRequest("GET", "/api/v1/sort?listId=missing", nil), cookie)
+	assert.Equal(http.StatusNotFound, w.Code)
+	assert.Equal(ErrCodeListNotFound, errorCode(t, w))
+	w = serve(h.WriteList, httptest.NewRequest("POST", "/api/v1/write", strings.NewReader(`{"listId":"missing"}`)), cookie)
+	assert.Equal(http.StatusNotFound, w.Code)
+	assert.Equal(ErrCodeListNotFound, errorCode(t, w))
+	w = serve(h.SortListById, httptest.NewRequest("GET", "/api/v1/sort?listId=tqtA2&sortMethod=nope", nil), cookie)
+	assert.Equal(http.StatusBadRequest, w.Code)
+	assert.Equal(ErrCodeBadRequest, errorCode(t, w))
+
+	// A client that has gone away isn't sorted for
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w = serve(h.SortListById, httptest.NewRequestWithContext(ctx, "GET", "/api/v1/sort?listId=tqtA2", nil), cookie)
+	assert.NotEqual(http.StatusOK, w.Code)
+
+	// Writes are checked before the list is loaded
+	w = serve(h.WriteList, httptest.NewRequest("POST", "/api/v1/write", strings.NewReader(`{"sortMethod":"hue"}`)), cookie)
+	assert.Equal(http.StatusBadRequest, w.Code)
+	w = serve(h.WriteList, httptest.NewRequest("POST", "/api/v1/write", strings.NewReader(`{"listId":"tqtA2","sortMethod":"nope"}`)), cookie)
+	assert.Equal(http.StatusBadRequest, w.Code)
+
+	// Grid sorts lay posters out in rows and columns, which an offset or reversal would break up
+	for _, body := range []string{`{"listId":"tqtA2","sortMethod":"grid_5","offset":1}`, `{"listId":"tqtA2","sortMethod":"grid_5","reverse":true}`} {
+		w = serve(h.WriteList, httptest.NewRequest("POST", "/api/v1/write", strings.NewReader(body)), cookie)
+		assert.Equal(http.StatusBadRequest, w.Code, body)
+	}
+	w = serve(h.SortListById, httptest.NewRequest("GET", "/api/v1/sort?listId=tqtA2&sortMethod=grid_5&reverse=true", nil), cookie)
+	assert.Equal(http.StatusBadRequest, w.Code)
+	w = serve(h.SortListById, httptest.NewRequest("GET", "/api/v1/sort?listId=tqtA2&sortMethod=grid_5", nil), cookie)
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	assert.Empty(fake.Patches("tqtA2"))
+}
+
+// Poster colour extraction, against generated posters rather than images fetched from the internet
+func TestLoadPosterImageInfo(t *testing.T) {
+	assert := assert.New(t)
+
+	fake := letterboxdtest.NewServer(&letterboxdtest.List{ID: "red", Films: []letterboxdtest.Film{{ID: "redFilm", Colors: []string{"#FF0000"}}}})
+	defer fake.Close()
+
+	img, err := loadImage(fake.URL + "/posters/redFilm?v=1")
+	assert.Nil(err)
+
+	entry, err := getImageInfo(Entry{}, img)
+	assert.Nil(err)
+	assert.Equal("#FF0000", entry.ImageInfo.Colors[0].hex)
+	assert.Equal(0.0, entry.ImageInfo.Colors[0].h)
+
+	_, err = loadImage(fake.URL + "/posters/missing?v=1")
+	assert.Equal(ErrCodePosterFetchFailed, toAPIError(err).Code)
+}
+
+// A write that fails part-way through is applied in batches, and can be resumed from the failed batch
+func TestWriteListResume(t *testing.T) {
+	assert := assert.New(t)
+
+	fake := letterboxdtest.NewServer(letterboxdtest.GradientList("big", 3*writeBatchSize))
+	defer fake.Close()
+	setTestEnv(t, fake.URL)
+	h := newTestHandlers(t)
+
+	cookie := signIn(t, h)
+	resume, _ := json.Marshal(ResumeWriteRequest{ListID: "big"})
+	w := serve(h.ResumeWrite, httptest.NewRequest("POST", "/api/v1/write/resume", bytes.NewReader(resume)), cookie)
+	assert.Equal(http.StatusNotFound, w.Code)
+
+	w = serve(h.SortListById, httptest.NewRequest("GET", "/api/v1/sort?listId=big", nil), cookie)
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	var sorted map[string][]Entry
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), &sorted))
+
+	var expected []string
+	for _, e := range sorted["items"] {
+		expected = append(expected, e.FilmID)
+	}
+
+	// The second batch fails, leaving the first applied
+	fake.FailPatch("big", 2)
+	body, _ := json.Marshal(WriteListRequest{
+		ListID:     "big",
+		SortMethod: "hue",
+	})
+	w = serve(h.WriteList, httptest.NewRequest("POST", "/api/v1/write", bytes.NewReader(body)), cookie)
+	assert.Equal(http.StatusBadGateway, w.Code)
+	assert.Contains(w.Body.String(), "can be resumed")
+	assert.Len(fake.Patches("big"), 1)
+	assert.Len(fake.Patches("big")[0].Entries, writeBatchSize)
+	assert.NotEqual(expected, fake.FilmIDs("big"))
+
+	w = serve(h.ResumeWrite, httptest.NewRequest("POST", "/api/v1/write/resume", bytes.NewReader(resume)), cookie)
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	patches := fake.Patches("big")
+	assert.Greater(len(patches), 2)
+	for i, p := range patches {
+		assert.Equal(1+i, p.Version)
+		assert.LessOrEqual(len(p.Entries), writeBatchSize)
+	}
+	assert.Equal(expected, fake.FilmIDs("big"))
+
+	// Once finished, there is nothing left to resume
+	w = serve(h.ResumeWrite, httptest.NewRequest("POST", "/api/v1/write/resume", bytes.NewReader(resume)), cookie)
+	assert.Equal(http.StatusNotFound, w.Code)
+
+	// A write that is rate limited part way through tells the client when to resume it
+	fake.RateLimitPatch("big", 2, 30)
+	body, _ = json.Marshal(WriteListRequest{
+		ListID:     "big",
+		SortMethod: "hue",
+		Reverse:    true,
+	})
+	w = serve(h.WriteList, httptest.NewRequest("POST", "/api/v1/write", bytes.NewReader(body)), cookie)
+	assert.Equal(http.StatusTooManyRequests, w.Code)
+	assert.Equal("30", w.Header().Get("Retry-After"))
+	assert.Contains(w.Body.String(), "can be resumed")
+}
+
+// A sort written to a list can be undone, restoring the list's original order
+func TestUndoWrite(t *testing.T) {
+	assert := assert.New(t)
+
+	fake := letterboxdtest.NewServer(letterboxdtest.GradientList("tqtA2", 30))
+	defer fake.Close()
+	setTestEnv(t, fake.URL)
+	h := newTestHandlers(t)
+	original := fake.FilmIDs("tqtA2")
+
+	cookie := signIn(t, h)
+	undo, _ := json.Marshal(UndoWriteRequest{ListID: "tqtA2"})
+	w := serve(h.UndoWrite, httptest.NewRequest("POST", "/api/v1/write/undo", bytes.NewReader(undo)), cookie)
+	assert.Equal(http.StatusNotFound, w.Code)
+
+	w = serve(h.SortListById, httptest.NewRequest("GET", "/api/v1/sort?listId=tqtA2", nil), cookie)
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	var sorted map[string][]Entry
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), &sorted))
+
+	body, _ := json.Marshal(WriteListRequest{
+		ListID:     "tqtA2",
+		SortMethod: "hue",
+		Reverse:    true,
+	})
+	w = serve(h.WriteList, httptest.NewRequest("POST", "/api/v1/write", bytes.NewReader(body)), cookie)
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	assert.NotEqual(original, fake.FilmIDs("tqtA2"))
+
+	w = serve(h.UndoWrite, httptest.NewRequest("POST", "/api/v1/write/undo", bytes.NewReader(undo)), cookie)
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(original, fake.FilmIDs("tqtA2"))
+	assert.Equal(3, fake.Version("tqtA2"))
+
+	// The snapshot is used up by the undo
+	w = serve(h.UndoWrite, httptest.NewRequest("POST", "/api/v1/write/undo", bytes.NewReader(undo)), cookie)
+	assert.Equal(http.StatusNotFound, w.Code)
+}
+
+// A write that conflicts with a change made to the list is planned again against the list's current order
+func TestWriteListConflict(t *testing.T) {
+	assert := assert.New(t)
+
+	fake := letterboxdtest.NewServer(letterboxdtest.GradientList("tqtA2", 30))
+	defer fake.Close()
+	setTestEnv(t, fake.URL)
+	h := newTestHandlers(t)
+
+	cookie := signIn(t, h)
+	w := serve(h.SortListById, httptest.NewRequest("GET", "/api/v1/sort?listId=tqtA2", nil), cookie)
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	var sorted map[string][]Entry
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), &sorted))
+	var expected []string
+	for _, e := range sorted["items"] {
+		expected = append(expected, e.FilmID)
+	}
+	body, _ := json.Marshal(WriteListRequest{
+		ListID:     "tqtA2",
+		SortMethod: "hue",
+	})
+
+	// The list is reordered elsewhere after it was sorted in the browser; the write is planned from its current order
+	lc := letterboxd.New(fake.URL, letterboxd.StaticToken(letterboxdtest.AccessToken))
+	_, err := lc.PatchList(context.Background(), "tqtA2", ListUpdateRequest{Version: 1, Entries: []listUpdateEntry{{Action: "UPDATE", Position: 0, NewPosition: 29}}})
+	assert.Nil(err)
+
+	// And is changed again while the write is being made, so the write is planned again and retried
+	fake.ConflictPatches("tqtA2", 1)
+	w = serve(h.WriteList, httptest.NewRequest("POST", "/api/v1/write", bytes.NewReader(body)), cookie)
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(expected, fake.FilmIDs("tqtA2"))
+	patches := fake.Patches("tqtA2")
+	assert.Len(patches, 3)
+	assert.Equal(patches[1].Version+1, patches[2].Version)
+
+	// If the retry conflicts too, the client is told to reload the list
+	fake.ConflictPatches("tqtA2", 2)
+	body, _ = json.Marshal(WriteListRequest{
+		ListID:     "tqtA2",
+		SortMethod: "hue",
+		Reverse:    true,
+	})
+	w = serve(h.WriteList, httptest.NewRequest("POST", "/api/v1/write", bytes.NewReader(body)), cookie)
+	assert.Equal(http.StatusConflict, w.Code)
+	assert.Equal(ErrCodeListVersionConflict, errorCode(t, w))
+
+	// A conflicted write can't be resumed
+	resume, _ := json.Marshal(ResumeWriteRequest{ListID: "tqtA2"})
+	w = serve(h.ResumeWrite, httptest.NewRequest("POST", "/api/v1/write/resume", bytes.NewReader(resume)), cookie)
+	assert.Equal(http.StatusNotFound, w.Code)
+}
+
+// A write that conflicts part way through is retried, and can still be undone to the list's original order
+func TestUndoWriteAfterConflict(t *testing.T) {
+	assert := assert.New(t)
+
+	fake := letterboxdtest.NewServer(letterboxdtest.GradientList("big", 3*writeBatchSize))
+	defer fake.Close()
+	setTestEnv(t, fake.URL)
+	h := newTestHandlers(t)
+	original := fake.FilmIDs("big")
+
+	cookie := signIn(t, h)
+	w := serve(h.SortListById, httptest.NewRequest("GET", "/api/v1/sort?listId=big", nil), cookie)
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	var sorted map[string][]Entry
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), &sorted))
+	var expected []string
+	for _, e := range sorted["items"] {
+		expected = append(expected, e.FilmID)
+	}
+
+	// The second batch conflicts, after the first has been applied
+	fake.ConflictPatch("big", 2)
+	body, _ := json.Marshal(WriteListRequest{
+		ListID:     "big",
+		SortMethod: "hue",
+	})
+	w = serve(h.WriteList, httptest.NewRequest("POST", "/api/v1/write", bytes.NewReader(body)), cookie)
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(expected, fake.FilmIDs("big"))
+
+	undo, _ := json.Marshal(UndoWriteRequest{ListID: "big"})
+	w = serve(h.UndoWrite, httptest.NewRequest("POST", "/api/v1/write/undo", bytes.NewReader(undo)), cookie)
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(original, fake.FilmIDs("big"))
+}
+
+// The session cookie is sent with cross-site requests, so requests that change a list must come from the
+// frontend, as JSON, or be rejected before anything is written
+func TestCrossSiteRequestsRejected(t *testing.T) {
+	assert := assert.New(t)
+
+	fake := letterboxdtest.NewServer(letterboxdtest.GradientList("tqtA2", 5))
+	defer fake.Close()
+	setTestEnv(t, fake.URL)
+	t.Setenv("BASE_URL", "https://colorboxd.com")
+	h := newTestHandlers(t)
+	cookie := signIn(t, h)
+
+	body := `{"listId":"tqtA2","sortMethod":"hue","reverse":true}`
+	for _, tc := range []struct {
+		handler     http.HandlerFunc
+		path        string
+		origin      string
+		contentType string
+		status      int
+		code        string
+	}{
+		{h.WriteList, "/api/v1/write", "https://evil.example", "application/json", http.StatusForbidden, ErrCodeForbidden},
+		{h.WriteList, "/api/v1/write", "https://evil.example", "text/plain", http.StatusForbidden, ErrCodeForbidden},
+		{h.WriteList, "/api/v1/write", "", "text/plain", http.StatusUnsupportedMediaType, ErrCodeUnsupportedMediaType},
+		{h.WriteList, "/api/v1/write", "", "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType, ErrCodeUnsupportedMediaType},
+		{h.UndoWrite, "/api/v1/write/undo", "https://evil.example", "application/json", http.StatusForbidden, ErrCodeForbidden},
+		{h.UndoWrite, "/api/v1/write/undo", "", "text/plain", http.StatusUnsupportedMediaType, ErrCodeUnsupportedMediaType},
+		{h.ResumeWrite, "/api/v1/write/resume", "https://evil.example", "application/json", http.StatusForbidden, ErrCodeForbidden},
+		{h.ResumeWrite, "/api/v1/write/resume", "", "text/plain", http.StatusUnsupportedMediaType, ErrCodeUnsupportedMediaType},
+		{h.RefreshAuth, "/api/v1/auth/refresh", "https://evil.example", "", http.StatusForbidden, ErrCodeForbidden},
+		{h.SignOut, "/api/v1/auth/signout", "https://evil.example", "", http.StatusForbidden, ErrCodeForbidden},
+	} {
+		r := httptest.NewRequest("POST", tc.path, strings.NewReader(body))
+		if tc.origin != "" {
+			r.Header.Set("Origin", tc.origin)
+		}
+		if tc.contentType != "" {
+			r.Header.Set("Content-Type", tc.contentType)
+		}
+		w := serve(tc.handler, r, cookie)
+		assert.Equal(tc.status, w.Code, tc.path)
+		assert.Equal(tc.code, errorCode(t, w), tc.path)
+	}
+	assert.Empty(fake.Patches("tqtA2"))
+
+	// The frontend's own requests are accepted
+	r := httptest.NewRequest("POST", "/api/v1/write", strings.NewReader(body))
+	r.Header.Set("Origin", "https://colorboxd.com")
+	r.Header.Set("Content-Type", "application/json; charset=utf-8")
+	w := serve(h.WriteList, r, cookie)
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	assert.NotEmpty(fake.Patches("tqtA2"))
+}
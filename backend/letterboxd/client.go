@@ -0,0 +1,158 @@
+package letterboxd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TokenSource supplies the bearer token used to authorise requests against the Letterboxd API.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// StaticToken is a TokenSource which always returns the same access token.
+type StaticToken string
+
+func (t StaticToken) Token(context.Context) (string, error) {
+	return string(t), nil
+}
+
+// Client is a thin, typed wrapper around the Letterboxd API.
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	Tokens     TokenSource // May be nil for unauthenticated requests (e.g. the auth/token exchange)
+}
+
+// New creates a Client for the Letterboxd API at baseURL. tokens may be nil.
+func New(baseURL string, tokens TokenSource) *Client {
+	return &Client{
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: &http.Client{
+			Timeout: time.Second * 30,
+		},
+		Tokens: tokens,
+	}
+}
+
+// AuthToken posts form to the auth/token endpoint. The form decides the grant type.
+func (c *Client) AuthToken(ctx context.Context, form url.Values) (*AccessTokenResponse, error) {
+	headers := map[string]string{"Content-Type": "application/x-www-form-urlencoded", "Accept": "application/json"}
+
+	var responseData AccessTokenResponse
+	if err := c.do(ctx, "POST", "/auth/token", strings.NewReader(form.Encode()), headers, false, &responseData); err != nil {
+		return nil, err
+	}
+	return &responseData, nil
+}
+
+// Me returns the member associated with the client's access token.
+func (c *Client) Me(ctx context.Context) (*Member, error) {
+	var responseData struct {
+		Member Member `json:"member"`
+	}
+	if err := c.do(ctx, "GET", "/me", nil, nil, true, &responseData); err != nil {
+		return nil, err
+	}
+	return &responseData.Member, nil
+}
+
+// Lists returns a single page of the lists owned by the given member.
+// An empty cursor requests the first page.
+func (c *Client) Lists(ctx context.Context, memberID, cursor string, perPage int) (*ListsResponse, error) {
+	query := url.Values{
+		"member":             {memberID},
+		"memberRelationship": {"Owner"},
+		"perPage":            {fmt.Sprint(perPage)},
+	}
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+
+	var responseData ListsResponse
+	if err := c.do(ctx, "GET", "/lists?"+query.Encode(), nil, nil, true, &responseData); err != nil {
+		return nil, err
+	}
+	return &responseData, nil
+}
+
+// List returns the metadata of a single list.
+func (c *Client) List(ctx context.Context, id string) (*List, error) {
+	var responseData List
+	if err := c.do(ctx, "GET", "/list/"+url.PathEscape(id), nil, nil, true, &responseData); err != nil {
+		return nil, err
+	}
+	return &responseData, nil
+}
+
+// ListEntries returns a single page of entries of a list.
+// An empty cursor requests the first page.
+func (c *Client) ListEntries(ctx context.Context, id, cursor string, perPage int) (*ListEntriesResponse, error) {
+	query := url.Values{"perPage": {fmt.Sprint(perPage)}}
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+
+	var responseData ListEntriesResponse
+	if err := c.do(ctx, "GET", "/list/"+url.PathEscape(id)+"/entries?"+query.Encode(), nil, nil, true, &responseData); err != nil {
+		return nil, err
+	}
+	return &responseData, nil
+}
+
+// PatchList sends a ListUpdateRequest to the list/{id} endpoint. Any messages returned by
+// Letterboxd are passed back to the caller to interpret.
+func (c *Client) PatchList(ctx context.Context, id string, update ListUpdateRequest) (*ListUpdateResponse, error) {
+	body, err := json.Marshal(update)
+	if err != nil {
+		return nil, err
+	}
+	headers := map[string]string{"Content-Type": "application/json", "X-HTTP-Method-Override": "PATCH"}
+
+	var responseData ListUpdateResponse
+	if err := c.do(ctx, "PATCH", "/list/"+url.PathEscape(id), bytes.NewReader(body), headers, true, &responseData); err != nil {
+		return nil, err
+	}
+	return &responseData, nil
+}
+
+// Makes a request of the required method to path (relative to BaseURL) and decodes the JSON response into out.
+// If response code is >= 400, returns an *UpstreamError describing it
+func (c *Client) do(ctx context.Context, method, path string, body io.Reader, headers map[string]string, auth bool, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if auth && c.Tokens != nil {
+		token, err := c.Tokens.Token(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get access token: %w", err)
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	}
+
+	response, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 400 {
+		return NewUpstreamError(response)
+	}
+
+	if err = json.NewDecoder(response.Body).Decode(out); err != nil {
+		return fmt.Errorf("error decoding letterboxd %s response: %w", path, err)
+	}
+	return nil
+}
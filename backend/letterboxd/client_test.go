@@ -0,0 +1,122 @@
+package letterboxd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Checks that each typed method hits the expected endpoint with the expected auth, and decodes the response
+func TestClientMethods(t *testing.T) {
+	assert := assert.New(t)
+
+	var lastPatch ListUpdateRequest
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /auth/token", func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(r.Header.Get("Authorization"))
+		r.ParseForm()
+		json.NewEncoder(w).Encode(AccessTokenResponse{AccessToken: "token-" + r.Form.Get("code"), RefreshToken: "refresh"})
+	})
+	mux.HandleFunc("GET /me", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("Bearer abc", r.Header.Get("Authorization"))
+		w.Write([]byte(`{"member":{"id":"67W7X","username":"tester"}}`))
+	})
+	mux.HandleFunc("GET /lists", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("67W7X", r.URL.Query().Get("member"))
+		assert.Equal("Owner", r.URL.Query().Get("memberRelationship"))
+		assert.Equal("start=100", r.URL.Query().Get("cursor"))
+		w.Write([]byte(`{"next":"start=200","items":[{"id":"tqtA2","name":"Test"}]}`))
+	})
+	mux.HandleFunc("GET /list/{id}", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(List{ID: r.PathValue("id"), FilmCount: 44})
+	})
+	mux.HandleFunc("GET /list/{id}/entries", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("start=100", r.URL.Query().Get("cursor"))
+		assert.Equal("100", r.URL.Query().Get("perPage"))
+		json.NewEncoder(w).Encode(ListEntriesResponse{Items: []ListEntries{{EntryID: "e1", Film: Film{ID: "f1"}}}})
+	})
+	mux.HandleFunc("PATCH /list/{id}", func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&lastPatch)
+		w.Write([]byte(`{"messages":[]}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx := context.Background()
+
+	token, err := New(srv.URL, nil).AuthToken(ctx, url.Values{"code": {"xyz"}})
+	assert.Nil(err)
+	assert.Equal("token-xyz", token.AccessToken)
+
+	c := New(srv.URL+"/", StaticToken("abc"))
+
+	member, err := c.Me(ctx)
+	assert.Nil(err)
+	assert.Equal("tester", member.Username)
+
+	lists, err := c.Lists(ctx, "67W7X", "start=100", 100)
+	assert.Nil(err)
+	assert.Equal("tqtA2", lists.Items[0].ID)
+	assert.Equal("start=200", lists.Next)
+
+	list, err := c.List(ctx, "tqtA2")
+	assert.Nil(err)
+	assert.Equal(List{ID: "tqtA2", FilmCount: 44}, *list)
+
+	entries, err := c.ListEntries(ctx, "tqtA2", "start=100", 100)
+	assert.Nil(err)
+	assert.Equal("f1", entries.Items[0].Film.ID)
+
+	update := ListUpdateRequest{Version: 3, Entries: []ListUpdateEntry{{Action: "UPDATE", Position: 2, NewPosition: 0}}}
+	res, err := c.PatchList(ctx, "tqtA2", update)
+	assert.Nil(err)
+	assert.Empty(res.Messages)
+	assert.Equal(update, lastPatch)
+}
+
+func TestClientErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	_, err := New(srv.URL, StaticToken("abc")).Me(context.Background())
+	assert.ErrorContains(t, err, "401")
+}
+
+func TestUpstreamError(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "12")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"code":"RateLimited","message":"Too many requests"}`))
+	}))
+	defer srv.Close()
+
+	_, err := New(srv.URL, StaticToken("abc")).List(context.Background(), "tqtA2")
+	var upstreamErr *UpstreamError
+	assert.ErrorAs(err, &upstreamErr)
+	assert.Equal(http.StatusTooManyRequests, upstreamErr.StatusCode)
+	assert.Equal(ErrorPayload{Code: "RateLimited", Message: "Too many requests"}, upstreamErr.Payload)
+	assert.Equal(12*time.Second, upstreamErr.RetryAfter)
+	assert.Equal("letterboxd responded with 429 Too Many Requests: Too many requests", err.Error())
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Zero(parseRetryAfter(""))
+	assert.Zero(parseRetryAfter("soon"))
+	assert.Equal(5*time.Second, parseRetryAfter("5"))
+
+	later := parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	assert.InDelta(time.Minute.Seconds(), later.Seconds(), 2)
+	assert.Zero(parseRetryAfter(time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat)))
+}
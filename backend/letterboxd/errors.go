@@ -0,0 +1,79 @@
+package letterboxd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The most of an error response body that is kept, so that a misbehaving server can't exhaust memory
+const maxErrorBodySize = 4096
+
+// UpstreamError is returned when Letterboxd (or its image servers) respond with an error status.
+type UpstreamError struct {
+	StatusCode int
+	Status     string
+	Payload    ErrorPayload  // Letterboxd's description of the error, where it gave one
+	RetryAfter time.Duration // How long Letterboxd asked us to wait before trying again; zero if it didn't say
+}
+
+// The body of an error response. The API describes errors with a code and message, while the
+// auth/token endpoint uses the OAuth error fields.
+type ErrorPayload struct {
+	Code             string `json:"code"`
+	Message          string `json:"message"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// NewUpstreamError reads the error from a response with an error status, and closes its body.
+func NewUpstreamError(response *http.Response) *UpstreamError {
+	defer response.Body.Close()
+
+	e := &UpstreamError{
+		StatusCode: response.StatusCode,
+		Status:     response.Status,
+		RetryAfter: parseRetryAfter(response.Header.Get("Retry-After")),
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(response.Body, maxErrorBodySize))
+	json.Unmarshal(body, &e.Payload) // The body is only informative, and may well not be JSON
+
+	return e
+}
+
+func (e *UpstreamError) Error() string {
+	msg := fmt.Sprintf("letterboxd responded with %s", e.Status)
+	if detail := e.Payload.detail(); detail != "" {
+		msg += ": " + detail
+	}
+	return msg
+}
+
+func (p ErrorPayload) detail() string {
+	switch {
+	case p.Message != "":
+		return p.Message
+	case p.ErrorDescription != "":
+		return p.ErrorDescription
+	default:
+		return p.Error
+	}
+}
+
+// Parses a Retry-After header, given either as a number of seconds or as a date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0)
+	}
+	return 0
+}
@@ -0,0 +1,433 @@
+// Package letterboxdtest provides an in-process fake of the Letterboxd API, for exercising
+// colorboxd end-to-end without network access or a real Letterboxd account.
+package letterboxdtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dsantos747/letterboxd_hue_sort/backend/letterboxd"
+	"github.com/lucasb-eyer/go-colorful"
+)
+
+// Credentials accepted by the fake server
+const (
+	AuthCode     = "fake-auth-code"
+	AccessToken  = "fake-access-token"
+	RefreshToken = "fake-refresh-token"
+)
+
+// The member that owns every list on the fake server
+var TestMember = letterboxd.Member{ID: "67W7X", DisplayName: "Test User", GivenName: "Test", Username: "tester"}
+
+// A film on the fake server. Its poster is generated from Colors, painted as horizontal bands
+// whose heights are proportional to Weights.
+type Film struct {
+	ID      string
+	Name    string
+	Colors  []string // hex colours, e.g. "#FF0000"
+	Weights []int
+}
+
+// A list on the fake server
+type List struct {
+	ID          string
+	Name        string
+	Description string
+	Version     int
+	Films       []Film
+}
+
+// Server is a fake Letterboxd API. Create one with NewServer, and point a letterboxd.Client at its URL.
+type Server struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	lists       []*List
+	patches     map[string][]letterboxd.ListUpdateRequest
+	failPatches map[string]int // Counts down the PATCH requests to each list until one fails
+	rateLimits  map[string]int // The Retry-After, in seconds, sent by a failing PATCH request that is rate limited
+	conflicts   map[string]int // The number of upcoming PATCH requests to each list that are preceded by a conflicting change
+	conflictAt  map[string]int // Counts down the PATCH requests to each list until one is preceded by a conflicting change
+}
+
+// NewServer starts a fake Letterboxd API serving the given lists. Call Close when done.
+func NewServer(lists ...*List) *Server {
+	s := &Server{lists: lists, patches: map[string][]letterboxd.ListUpdateRequest{}, failPatches: map[string]int{}, rateLimits: map[string]int{}, conflicts: map[string]int{}, conflictAt: map[string]int{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /auth/token", s.handleToken)
+	mux.HandleFunc("GET /me", s.authed(s.handleMe))
+	mux.HandleFunc("GET /lists", s.authed(s.handleLists))
+	mux.HandleFunc("GET /list/{id}", s.authed(s.handleList))
+	mux.HandleFunc("GET /list/{id}/entries", s.authed(s.handleListEntries))
+	mux.HandleFunc("PATCH /list/{id}", s.authed(s.handlePatchList))
+	mux.HandleFunc("GET /posters/{filmId}", s.handlePoster)
+
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Returns a list with n films, whose posters step evenly around the hue wheel with a dark secondary band.
+// The films are listed in a scrambled (but deterministic) order.
+func GradientList(id string, n int) *List {
+	list := &List{ID: id, Name: fmt.Sprintf("Gradient %s", id), Version: 1}
+	for i, step := range rand.New(rand.NewSource(int64(n))).Perm(n) {
+		hue := float64(step) * 360 / float64(n)
+		list.Films = append(list.Films, Film{
+			ID:      fmt.Sprintf("%sf%d", id, i),
+			Name:    fmt.Sprintf("Film %d", i),
+			Colors:  []string{colorful.Hsl(hue, 0.8, 0.5).Hex(), "#101010"},
+			Weights: []int{3, 1},
+		})
+	}
+	return list
+}
+
+// Returns the PATCH requests made to a list, in the order they were received
+func (s *Server) Patches(id string) []letterboxd.ListUpdateRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]letterboxd.ListUpdateRequest{}, s.patches[id]...)
+}
+
+// Makes the nth PATCH request to a list from now on fail with 503 Service Unavailable, leaving the list untouched
+func (s *Server) FailPatch(id string, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failPatches[id] = n
+	delete(s.rateLimits, id)
+}
+
+// Makes the nth PATCH request to a list from now on fail with 429 Too Many Requests, asking the client to retry
+// after the given number of seconds
+func (s *Server) RateLimitPatch(id string, n, retryAfter int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failPatches[id] = n
+	s.rateLimits[id] = retryAfter
+}
+
+// Makes the next n PATCH requests to a list conflict with a change made just before each, as if someone else
+// were editing it at the same time. The list's version is bumped, so the updates are rejected as outdated.
+func (s *Server) ConflictPatches(id string, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conflicts[id] = n
+}
+
+// Makes the nth PATCH request to a list from now on conflict with a change made just before it, as ConflictPatches does
+func (s *Server) ConflictPatch(id string, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conflictAt[id] = n
+}
+
+func (s *Server) findList(id string) *List {
+	for _, l := range s.lists {
+		if l.ID == id {
+			return l
+		}
+	}
+	return nil
+}
+
+// Rejects requests without the fake access token
+func (s *Server) authed(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+AccessToken {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		next(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad form", http.StatusBadRequest)
+		return
+	}
+	grant := r.Form.Get("grant_type")
+	valid := (grant == "authorization_code" && r.Form.Get("code") == AuthCode) ||
+		(grant == "refresh_token" && r.Form.Get("refresh_token") == RefreshToken)
+	if !valid {
+		http.Error(w, "invalid grant", http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, letterboxd.AccessTokenResponse{
+		AccessToken:  AccessToken,
+		TokenType:    "Bearer",
+		RefreshToken: RefreshToken,
+		ExpiresIn:    3600,
+	})
+}
+
+func (s *Server) handleMe(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]letterboxd.Member{"member": TestMember})
+}
+
+// Parses the "start=N" cursor and perPage parameters used for pagination
+func pageParams(r *http.Request) (start, perPage int) {
+	start, _ = strconv.Atoi(strings.TrimPrefix(r.URL.Query().Get("cursor"), "start="))
+	perPage, err := strconv.Atoi(r.URL.Query().Get("perPage"))
+	if err != nil || perPage < 1 {
+		perPage = 20
+	}
+	return start, perPage
+}
+
+func (s *Server) handleLists(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("member") != TestMember.ID {
+		writeJSON(w, letterboxd.ListsResponse{Items: []letterboxd.ListSummary{}})
+		return
+	}
+
+	start, perPage := pageParams(r)
+	res := letterboxd.ListsResponse{Items: []letterboxd.ListSummary{}}
+	for i := start; i < min(start+perPage, len(s.lists)); i++ {
+		res.Items = append(res.Items, s.lists[i].summary())
+	}
+	if start+perPage < len(s.lists) {
+		res.Next = fmt.Sprintf("start=%d", start+perPage)
+	}
+	writeJSON(w, res)
+}
+
+func (l *List) summary() letterboxd.ListSummary {
+	return letterboxd.ListSummary{ID: l.ID, Name: l.Name, Version: l.Version, FilmCount: len(l.Films), Description: l.Description}
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	list := s.findList(r.PathValue("id"))
+	if list == nil {
+		http.Error(w, "list not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, letterboxd.List{ID: list.ID, Version: list.Version, FilmCount: int32(len(list.Films))})
+}
+
+func (s *Server) handleListEntries(w http.ResponseWriter, r *http.Request) {
+	list := s.findList(r.PathValue("id"))
+	if list == nil {
+		http.Error(w, "list not found", http.StatusNotFound)
+		return
+	}
+
+	start, perPage := pageParams(r)
+	res := letterboxd.ListEntriesResponse{Items: []letterboxd.ListEntries{}}
+	for i := start; i < min(start+perPage, len(list.Films)); i++ {
+		f := list.Films[i]
+		poster := letterboxd.CoverImg{Sizes: []letterboxd.ImgSize{{Width: 70, Height: 105, URL: fmt.Sprintf("%s/posters/%s?v=1", s.URL, f.ID)}}}
+		res.Items = append(res.Items, letterboxd.ListEntries{
+			EntryID: "entry-" + f.ID,
+			Film:    letterboxd.Film{ID: f.ID, Name: f.Name, Poster: poster, ReleaseYear: 2000 + i},
+		})
+	}
+	if start+perPage < len(list.Films) {
+		res.Next = fmt.Sprintf("start=%d", start+perPage)
+	}
+	writeJSON(w, res)
+}
+
+// Message codes returned by the fake server when it rejects a list update
+const (
+	CodeVersionMismatch = letterboxd.MessageListVersionMismatch
+	CodeInvalidPosition = "InvalidListPosition"
+	CodeInvalidAction   = "InvalidListAction"
+)
+
+func (s *Server) handlePatchList(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	list := s.findList(id)
+	if list == nil {
+		http.Error(w, "list not found", http.StatusNotFound)
+		return
+	}
+
+	if n, ok := s.failPatches[id]; ok {
+		if n <= 1 {
+			delete(s.failPatches, id)
+			if retryAfter, ok := s.rateLimits[id]; ok {
+				delete(s.rateLimits, id)
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				http.Error(w, "too many requests", http.StatusTooManyRequests)
+				return
+			}
+			http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		s.failPatches[id] = n - 1
+	}
+
+	var update letterboxd.ListUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, "bad request body", http.StatusBadRequest)
+		return
+	}
+	s.patches[id] = append(s.patches[id], update)
+
+	if s.conflicts[id] > 0 {
+		s.conflicts[id]--
+		list.Version++
+	}
+	if n, ok := s.conflictAt[id]; ok {
+		if n <= 1 {
+			delete(s.conflictAt, id)
+			list.Version++
+		} else {
+			s.conflictAt[id] = n - 1
+		}
+	}
+
+	// Updates are made against a specific version of the list, and rejected if it has since changed
+	if update.Version != list.Version {
+		writeJSON(w, errorResponse(CodeVersionMismatch, fmt.Sprintf("List version is %d, update was made against version %d", list.Version, update.Version)))
+		return
+	}
+
+	films, msg := ApplyListUpdate(list.Films, update.Entries)
+	if msg != nil {
+		writeJSON(w, letterboxd.ListUpdateResponse{Messages: []letterboxd.ListUpdateMessage{*msg}})
+		return
+	}
+	list.Films = films
+	list.Version++
+
+	writeJSON(w, letterboxd.ListUpdateResponse{
+		Data:     letterboxd.List{ID: list.ID, Version: list.Version, FilmCount: int32(len(list.Films))},
+		Messages: []letterboxd.ListUpdateMessage{},
+	})
+}
+
+func errorResponse(code, title string) letterboxd.ListUpdateResponse {
+	return letterboxd.ListUpdateResponse{Messages: []letterboxd.ListUpdateMessage{{Type: "Error", Code: code, Title: title}}}
+}
+
+// Applies list update actions in turn, as Letterboxd does: an UPDATE removes the film at Position, then
+// inserts it at NewPosition (counted in the list with the film removed), shifting the films in between.
+// The update is all or nothing; if any action is invalid, the films are returned unchanged with an error message.
+func ApplyListUpdate(films []Film, entries []letterboxd.ListUpdateEntry) ([]Film, *letterboxd.ListUpdateMessage) {
+	result := append([]Film{}, films...)
+	for i, e := range entries {
+		if e.Action != "UPDATE" {
+			return films, &errorResponse(CodeInvalidAction, fmt.Sprintf("Entry %d: unsupported action %q", i, e.Action)).Messages[0]
+		}
+		if e.Position < 0 || e.Position >= len(result) || e.NewPosition < 0 || e.NewPosition >= len(result) {
+			return films, &errorResponse(CodeInvalidPosition, fmt.Sprintf("Entry %d: position out of range", i)).Messages[0]
+		}
+
+		film := result[e.Position]
+		result = slices.Delete(result, e.Position, e.Position+1)
+		result = slices.Insert(result, e.NewPosition, film)
+	}
+	return result, nil
+}
+
+// Returns the IDs of the films in a list, in list order
+func (s *Server) FilmIDs(id string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := []string{}
+	if list := s.findList(id); list != nil {
+		for _, f := range list.Films {
+			ids = append(ids, f.ID)
+		}
+	}
+	return ids
+}
+
+// Returns the current version of a list, or 0 if it doesn't exist
+func (s *Server) Version(id string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if list := s.findList(id); list != nil {
+		return list.Version
+	}
+	return 0
+}
+
+// Serves a generated PNG poster for a film
+func (s *Server) handlePoster(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	var film *Film
+	for _, l := range s.lists {
+		for i := range l.Films {
+			if l.Films[i].ID == r.PathValue("filmId") {
+				film = &l.Films[i]
+			}
+		}
+	}
+	s.mu.Unlock()
+	if film == nil {
+		http.Error(w, "poster not found", http.StatusNotFound)
+		return
+	}
+
+	img, err := PosterImage(film.Colors, film.Weights)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	png.Encode(w, img)
+}
+
+// Generates a 70x105 poster painted with horizontal bands of the given colours.
+// Band heights are proportional to weights; if weights is empty, bands are equal.
+func PosterImage(hexes []string, weights []int) (image.Image, error) {
+	const width, height = 70, 105
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	total := 0
+	for i := range hexes {
+		total += weightAt(weights, i)
+	}
+
+	y := 0
+	for i, hex := range hexes {
+		c, err := colorful.Hex(hex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid poster colour %q: %w", hex, err)
+		}
+		band := height * weightAt(weights, i) / total
+		if i == len(hexes)-1 {
+			band = height - y
+		}
+		r, g, b := c.RGB255()
+		for ; band > 0; band-- {
+			for x := range width {
+				img.Set(x, y, color.RGBA{r, g, b, 255})
+			}
+			y++
+		}
+	}
+	return img, nil
+}
+
+func weightAt(weights []int, i int) int {
+	if i < len(weights) {
+		return weights[i]
+	}
+	return 1
+}
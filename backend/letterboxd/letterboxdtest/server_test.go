@@ -0,0 +1,45 @@
+package letterboxdtest
+
+import (
+	"testing"
+
+	"github.com/dsantos747/letterboxd_hue_sort/backend/letterboxd"
+	"github.com/stretchr/testify/assert"
+)
+
+func filmIDs(films []Film) []string {
+	ids := []string{}
+	for _, f := range films {
+		ids = append(ids, f.ID)
+	}
+	return ids
+}
+
+func TestApplyListUpdate(t *testing.T) {
+	assert := assert.New(t)
+	films := []Film{{ID: "a"}, {ID: "b"}, {ID: "c"}, {ID: "d"}}
+
+	testCases := []struct {
+		name     string
+		entries  []letterboxd.ListUpdateEntry
+		expected []string
+		code     string
+	}{
+		{name: "Move to front", entries: []letterboxd.ListUpdateEntry{{Action: "UPDATE", Position: 3, NewPosition: 0}}, expected: []string{"d", "a", "b", "c"}},
+		{name: "Move to back", entries: []letterboxd.ListUpdateEntry{{Action: "UPDATE", Position: 0, NewPosition: 3}}, expected: []string{"b", "c", "d", "a"}},
+		{name: "Moves apply in turn", entries: []letterboxd.ListUpdateEntry{{Action: "UPDATE", Position: 3, NewPosition: 0}, {Action: "UPDATE", Position: 3, NewPosition: 1}}, expected: []string{"d", "c", "a", "b"}},
+		{name: "Out of range", entries: []letterboxd.ListUpdateEntry{{Action: "UPDATE", Position: 0, NewPosition: 1}, {Action: "UPDATE", Position: 4, NewPosition: 0}}, expected: []string{"a", "b", "c", "d"}, code: CodeInvalidPosition},
+		{name: "Unknown action", entries: []letterboxd.ListUpdateEntry{{Action: "DELETE", Position: 0}}, expected: []string{"a", "b", "c", "d"}, code: CodeInvalidAction},
+	}
+
+	for _, tc := range testCases {
+		result, msg := ApplyListUpdate(films, tc.entries)
+		assert.Equal(tc.expected, filmIDs(result), tc.name)
+		if tc.code == "" {
+			assert.Nil(msg, tc.name)
+		} else {
+			assert.Equal(tc.code, msg.Code, tc.name)
+		}
+	}
+	assert.Equal([]string{"a", "b", "c", "d"}, filmIDs(films), "input is never modified")
+}
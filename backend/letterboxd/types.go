@@ -0,0 +1,97 @@
+package letterboxd
+
+// The response format from Letterboxd auth/token endpoint
+type AccessTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	NotBefore    int    `json:"notBefore"`
+	Issuer       string `json:"issuer"`
+	EncodedToken string `json:"encodedToken"`
+}
+
+// The response format from Letterboxd /me endpoint
+type Member struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"displayName"`
+	GivenName   string `json:"givenName"`
+	Username    string `json:"username"`
+}
+
+// The response format from Letterboxd /lists endpoint
+type ListsResponse struct {
+	Next  string        `json:"next,omitempty"` // Cursor to the next page of results, passed back as the cursor parameter; empty on the last page
+	Items []ListSummary `json:"items"`
+}
+
+// Summary information about a list. In our case, one of the user's lists
+type ListSummary struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Version     int    `json:"version"`
+	FilmCount   int    `json:"filmCount"`
+	Description string `json:"description"`
+}
+
+// The (partial) response format from Letterboxd list/{id} endpoint
+type List struct {
+	ID        string `json:"id"`
+	Version   int    `json:"version"`
+	FilmCount int32  `json:"filmCount"`
+}
+
+// The (partial) response format from Letterboxd list/{id}/entries endpoint
+type ListEntriesResponse struct {
+	Next  string        `json:"next"`
+	Items []ListEntries `json:"items"`
+}
+type ListEntries struct {
+	EntryID string `json:"entryId"`
+	Film    Film   `json:"film"`
+}
+type Film struct {
+	Adult              bool     `json:"adult"`
+	ID                 string   `json:"id"`
+	Name               string   `json:"name"`
+	Poster             CoverImg `json:"poster"`
+	AdultPoster        CoverImg `json:"adultPoster"`
+	PosterCustomisable bool     `json:"posterCustomisable"`
+	ReleaseYear        int      `json:"releaseYear"`
+}
+type CoverImg struct {
+	Sizes []ImgSize `json:"sizes"`
+}
+type ImgSize struct {
+	Height int    `json:"height"`
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+}
+
+// This is the required format for making a PATCH request to letterboxd list/{id} endpoint.
+//
+// Note: This struct only includes parameters we are interested in controlling/modifying
+type ListUpdateRequest struct {
+	Version int               `json:"version"`
+	Entries []ListUpdateEntry `json:"entries"`
+}
+type ListUpdateEntry struct {
+	Action      string `json:"action"`
+	Position    int    `json:"position"`
+	NewPosition int    `json:"newPosition"`
+}
+
+// This is the response format from a PATCH request to the letterboxd list/{id} endpoint.
+type ListUpdateResponse struct {
+	Data     List                `json:"data"` // The list as it stands after the update
+	Messages []ListUpdateMessage `json:"messages"`
+}
+
+// The code of the message Letterboxd responds with when an update was made against an outdated version of the list
+const MessageListVersionMismatch = "ListVersionMismatch"
+
+type ListUpdateMessage struct {
+	Type  string `json:"type"`
+	Code  string `json:"code"`
+	Title string `json:"title"`
+}
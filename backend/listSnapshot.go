@@ -0,0 +1,91 @@
+package colorboxd
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/dsantos747/letterboxd_hue_sort/backend/redis"
+)
+
+const listSnapshotTTL = 30 * 24 * time.Hour // How long a sort can be undone for
+
+// The order of a list before it was last sorted, so that the sort can be undone
+type ListSnapshot struct {
+	ListID  string          `json:"listId"`
+	Entries []SnapshotEntry `json:"entries"`
+}
+type SnapshotEntry struct {
+	EntryID      string `json:"entryId"`
+	ListPosition int    `json:"listPosition"`
+}
+
+func newListSnapshot(list ListWithEntries) *ListSnapshot {
+	snapshot := &ListSnapshot{ListID: list.ID}
+	for _, entry := range list.Entries {
+		snapshot.Entries = append(snapshot.Entries, SnapshotEntry{EntryID: entry.EntryID, ListPosition: entry.ListPosition})
+	}
+	return snapshot
+}
+
+// Loads the snapshot of a user's list taken before it was last sorted, if there is one
+func loadListSnapshot(rc redis.Redis, userID, listID string) (*ListSnapshot, bool, error) {
+	val, found, err := rc.GetListSnapshot(userListKey(userID, listID))
+	if err != nil || !found {
+		return nil, false, err
+	}
+
+	var snapshot ListSnapshot
+	if err := json.Unmarshal(val, &snapshot); err != nil {
+		return nil, false, fmt.Errorf("failed to decode list snapshot: %w", err)
+	}
+	return &snapshot, true, nil
+}
+
+func saveListSnapshot(rc redis.Redis, userID string, snapshot *ListSnapshot) error {
+	val, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to encode list snapshot: %w", err)
+	}
+	return rc.SetListSnapshot(userListKey(userID, snapshot.ListID), val, listSnapshotTTL)
+}
+
+// Returns a ListUpdateRequest that puts the list's current entries back into the order of the snapshot.
+// Entries added to the list since the snapshot are kept after the others, in their current order, and
+// entries that have since been removed are ignored.
+func prepareUndoRequest(snapshot *ListSnapshot, entries []Entry, version int) ListUpdateRequest {
+	// Entry ids identify the films being moved, as film ids do when sorting
+	currentPositions := make(map[string]int)
+	for _, entry := range entries {
+		currentPositions[entry.EntryID] = entry.ListPosition
+	}
+
+	snapshotEntries := slices.Clone(snapshot.Entries)
+	slices.SortFunc(snapshotEntries, func(a, b SnapshotEntry) int {
+		return cmp.Compare(a.ListPosition, b.ListPosition)
+	})
+
+	var finishSlice []FilmTargetPosition
+	restored := make(map[string]bool)
+	for _, entry := range snapshotEntries {
+		if _, ok := currentPositions[entry.EntryID]; ok && !restored[entry.EntryID] {
+			finishSlice = append(finishSlice, FilmTargetPosition{entry.EntryID, len(finishSlice)})
+			restored[entry.EntryID] = true
+		}
+	}
+
+	added := slices.Clone(entries)
+	slices.SortFunc(added, func(a, b Entry) int {
+		return cmp.Compare(a.ListPosition, b.ListPosition)
+	})
+	for _, entry := range added {
+		if !restored[entry.EntryID] {
+			finishSlice = append(finishSlice, FilmTargetPosition{entry.EntryID, len(finishSlice)})
+		}
+	}
+
+	plan := createListUpdateEntries(currentPositions, finishSlice)
+	return ListUpdateRequest{Version: version, Entries: plan.Entries}
+}
@@ -0,0 +1,39 @@
+package colorboxd
+
+import (
+	"testing"
+
+	"github.com/dsantos747/letterboxd_hue_sort/backend/letterboxd/letterboxdtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// Entries added since the snapshot go last, and entries since removed are skipped
+func TestPrepareUndoRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	snapshot := &ListSnapshot{ListID: "list", Entries: []SnapshotEntry{
+		{EntryID: "a", ListPosition: 0},
+		{EntryID: "b", ListPosition: 1},
+		{EntryID: "removed", ListPosition: 2},
+		{EntryID: "c", ListPosition: 3},
+	}}
+
+	current := []string{"new", "c", "b", "a"}
+	var entries []Entry
+	var films []letterboxdtest.Film
+	for i, id := range current {
+		entries = append(entries, Entry{ListPosition: i, EntryID: id})
+		films = append(films, letterboxdtest.Film{ID: id})
+	}
+
+	request := prepareUndoRequest(snapshot, entries, 7)
+	assert.Equal(7, request.Version)
+
+	films, msg := letterboxdtest.ApplyListUpdate(films, request.Entries)
+	assert.Nil(msg)
+	var order []string
+	for _, f := range films {
+		order = append(order, f.ID)
+	}
+	assert.Equal([]string{"a", "b", "c", "new"}, order)
+}
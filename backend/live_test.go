@@ -1,3 +1,5 @@
+//go:build live
+
 package colorboxd
 
 import (
@@ -65,7 +67,7 @@ func TestGetAccessTokenAndUser(t *testing.T) {
 		t.Errorf("failed to generate auth code: %v", err)
 	}
 
-	accessTokenResponse, err := getAccessToken(*authCode)
+	accessTokenResponse, err := getAccessToken(context.Background(), *authCode)
 	if err != nil {
 		t.Errorf("could not create valid access token for provided auth code: %v", err)
 	}
@@ -74,7 +76,7 @@ func TestGetAccessTokenAndUser(t *testing.T) {
 		t.Errorf("no valid access token present in response: %v", err)
 	}
 
-	member, err := getMemberId(accessTokenResponse.AccessToken)
+	member, err := getMemberId(context.Background(), newLetterboxdClient(accessTokenResponse.AccessToken))
 	if err != nil {
 		t.Errorf("could not retrieve member ID: %v", err)
 	}
@@ -126,7 +128,7 @@ func getAuthCode() (*string, error) {
 }
 
 func TestGetLists(t *testing.T) {
-	userLists, err := getUserLists(testToken, testUserId)
+	userLists, err := getUserLists(context.Background(), newLetterboxdClient(testToken), testUserId)
 	if err != nil {
 		t.Errorf("could not retrieve lists from Letterboxd API: %v", err)
 	}
@@ -143,7 +145,7 @@ func TestGetLists(t *testing.T) {
 // the expected amount, fail test.
 func TestGetListEntries(t *testing.T) {
 	var err error
-	testListEntries, err = getListEntries(context.Background(), testToken, testListId)
+	testListEntries, err = getListEntries(context.Background(), newLetterboxdClient(testToken), testListId)
 	if err != nil {
 		t.Errorf("failed to retrieve entries from list: %v", err)
 	}
@@ -155,7 +157,7 @@ func TestGetListEntries(t *testing.T) {
 // For all images in test list, try extracting dominant colour information from posters.
 // If no dominant colours are found, something's wrong - fail test.
 func TestProcessListImages(t *testing.T) {
-	entriesWithImageInfo, err := processListImages(testListEntries)
+	entriesWithImageInfo, err := processListImages(newMemoryCache(defaultColorCacheSize), testListEntries)
 	if err != nil {
 		t.Errorf("failed to process posters for list entries: %v", err)
 		return
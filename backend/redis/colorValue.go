@@ -0,0 +1,118 @@
+package redis
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The version of the colour value format written by encodeColorValue. Bump it whenever the format changes
+// in a way older code can't read, and keep decoding the previous version until its values have expired.
+const colorValueVersion = 1
+
+// The colours extracted from a poster, as stored in the colour cache
+type ColorValue struct {
+	Colors    []string
+	Counts    []int
+	Algorithm string    // The extraction algorithm that produced the colours; empty for legacy values
+	CachedAt  time.Time // When the colours were cached; zero for legacy values
+}
+
+// The stored form of a ColorValue
+type encodedColorValue struct {
+	Version   int      `json:"v"`
+	Colors    []string `json:"colors"`
+	Counts    []int    `json:"counts"`
+	Algorithm string   `json:"algorithm,omitempty"`
+	CachedAt  int64    `json:"cachedAt"` // Unix seconds
+}
+
+// Encodes a value to store in redis. The value is stamped with the current time if it has no CachedAt.
+func encodeColorValue(value ColorValue) (string, error) {
+	if len(value.Colors) != len(value.Counts) {
+		return "", fmt.Errorf("length of colors and counts do not match")
+	}
+	for i, color := range value.Colors {
+		if len(color) != 7 || color[0] != '#' {
+			return "", fmt.Errorf("weird color string: %q", color)
+		}
+		if value.Counts[i] < 0 {
+			return "", fmt.Errorf("color count is out of range: %d", value.Counts[i])
+		}
+	}
+
+	cachedAt := value.CachedAt
+	if cachedAt.IsZero() {
+		cachedAt = time.Now()
+	}
+
+	val, err := json.Marshal(encodedColorValue{
+		Version:   colorValueVersion,
+		Colors:    value.Colors,
+		Counts:    value.Counts,
+		Algorithm: value.Algorithm,
+		CachedAt:  cachedAt.Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(val), nil
+}
+
+// Decodes a value stored in redis, in either the current format or the legacy fixed-width one
+func decodeColorValue(val string) (ColorValue, error) {
+	if !strings.HasPrefix(val, "{") {
+		return decodeLegacyColorValue(val)
+	}
+
+	var encoded encodedColorValue
+	if err := json.Unmarshal([]byte(val), &encoded); err != nil {
+		return ColorValue{}, fmt.Errorf("failed to decode color value: %w", err)
+	}
+	if encoded.Version != colorValueVersion {
+		return ColorValue{}, fmt.Errorf("unsupported color value version %d", encoded.Version)
+	}
+	if len(encoded.Colors) != len(encoded.Counts) {
+		return ColorValue{}, fmt.Errorf("length of colors and counts do not match")
+	}
+
+	return ColorValue{
+		Colors:    encoded.Colors,
+		Counts:    encoded.Counts,
+		Algorithm: encoded.Algorithm,
+		CachedAt:  time.Unix(encoded.CachedAt, 0),
+	}, nil
+}
+
+// Decodes the legacy format of exactly three comma-separated "#RRGGBBnnnn" tokens, padded with
+// "XXXXXXX0000". Values in this format are no longer written; this can be removed once they have all expired.
+func decodeLegacyColorValue(val string) (ColorValue, error) {
+	colors := []string{}
+	counts := []int{}
+
+	slc := strings.Split(val, ",")
+
+	if len(slc) != 3 {
+		return ColorValue{}, fmt.Errorf("unexpected length of value fetched from redis; length %d", len(slc))
+	}
+
+	for _, c := range slc {
+		if len(c) < 8 {
+			return ColorValue{}, fmt.Errorf("unexpected token in value fetched from redis: %q", c)
+		}
+		count, err := strconv.Atoi(c[7:])
+		if err != nil || count < 0 {
+			return ColorValue{}, fmt.Errorf("invalid count post-conversion: %w", err)
+		}
+
+		// Padding tokens are skipped; otherwise a colour and its count are kept together, even if the count is zero
+		if c[:7] == "XXXXXXX" {
+			continue
+		}
+		colors = append(colors, c[:7])
+		counts = append(counts, count)
+	}
+	return ColorValue{Colors: colors, Counts: counts}, nil
+}
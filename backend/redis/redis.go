@@ -4,7 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"strconv"
+	"log/slog"
 	"strings"
 	"time"
 
@@ -13,29 +13,47 @@ import (
 
 const ttlDays int64 = 30
 
+// How long cached colours are kept for
+const colorTTL = time.Duration(ttlDays*24) * time.Hour
+
 type Redis struct {
 	client *redis.Client
 }
 
 type CacheResponse struct {
-	Colors []string
-	Counts []int
-	Hit    bool
+	ColorValue
+	Hit bool
 }
 
-func New(url string) Redis {
-	var client *redis.Client
+// How long New waits for redis to answer its ping
+const pingTimeout = 5 * time.Second
+
+// New connects to the redis at the given url, checking that it can be reached
+func New(url string) (Redis, error) {
 	opt, err := redis.ParseURL(url)
+	if err != nil {
+		return Redis{}, fmt.Errorf("invalid redis url: %w", err)
+	}
 
 	opt.MaxActiveConns = 10 // free tier offers 30, so this allows 3 users to use the app concurrently
 
-	if err == nil {
-		client = redis.NewClient(opt)
+	client := redis.NewClient(opt)
+
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return Redis{}, fmt.Errorf("failed to reach redis: %w", err)
 	}
 
 	return Redis{
 		client: client,
-	}
+	}, nil
+}
+
+// Close closes the connection to redis
+func (r Redis) Close() error {
+	return r.client.Close()
 }
 
 func (r Redis) GetBatch(keys []string) (map[string]CacheResponse, error) {
@@ -59,9 +77,9 @@ func (r Redis) GetBatch(keys []string) (map[string]CacheResponse, error) {
 			return nil, fmt.Errorf("failed to convert redis response to string")
 		}
 
-		colors, counts, err := r.parseRedisOut(vals)
+		value, err := decodeColorValue(vals)
 		if err != nil {
-			fmt.Println("error parsing output from redis: %w", err)
+			slog.Default().Warn("failed to decode colours from redis; treating as a miss", "key", key, "err", err)
 			res[key] = CacheResponse{
 				Hit: false,
 			}
@@ -69,33 +87,45 @@ func (r Redis) GetBatch(keys []string) (map[string]CacheResponse, error) {
 		}
 
 		res[key] = CacheResponse{
-			Colors: colors,
-			Counts: counts,
-			Hit:    true,
+			ColorValue: value,
+			Hit:        true,
 		}
 	}
 
 	return res, nil
 }
 
-func (r Redis) SetBatch(keys []string, colors [][]string, counts [][]int) error {
-	if len(keys) != len(colors) || len(keys) != len(counts) || len(colors) != len(counts) {
-		return fmt.Errorf("length of keys, colors, and counts do not match")
+// Sets a batch of values, each expiring after the same TTL as Set. The batch is set in a single MULTI/EXEC
+// transaction, so either every value is set or none are.
+func (r Redis) SetBatch(keys []string, values []ColorValue) error {
+	if len(keys) != len(values) {
+		return fmt.Errorf("length of keys and values do not match")
 	}
 
-	kv := []any{}
-	for i, col := range colors {
-		val, err := r.parseRedisIn(col, counts[i])
+	vals := make([]string, len(values))
+	for i, value := range values {
+		if !strings.Contains(keys[i], "_") {
+			return fmt.Errorf("invalid redis key format: %q", keys[i])
+		}
+		val, err := encodeColorValue(value)
 		if err != nil {
 			return fmt.Errorf("error parsing redis input: %w", err)
 		}
-		kv = append(kv, keys[i], val)
+		vals[i] = val
+	}
+	if len(keys) == 0 {
+		return nil
 	}
 
-	// TODO - WRAP THIS IN A TRANSACTION, TO ENSURE EITHER ALL IS SET OR NONE (NO PARTIAL SETS)
-	resInt := r.client.MSet(context.TODO(), kv...)
-	if resInt.Err() != nil || resInt.Val() == "" {
-		return fmt.Errorf("error batch-setting to redis: %w", resInt.Err()) // If logs show nil err, then val == ""
+	ctx := context.TODO()
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			pipe.Set(ctx, key, vals[i], colorTTL)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error batch-setting to redis: %w", err)
 	}
 	return nil
 }
@@ -110,75 +140,139 @@ func (r Redis) Get(key string) (CacheResponse, error) {
 		}
 		return CacheResponse{}, fmt.Errorf("error getting from redis: %w", err) // If logs show nil err, then val == ""
 	}
-	colors, counts, err := r.parseRedisOut(vals)
+	value, err := decodeColorValue(vals)
 	if err != nil {
 		return CacheResponse{Hit: false}, fmt.Errorf("error parsing output from redis: %w", err)
 	}
 
-	return CacheResponse{Colors: colors, Counts: counts, Hit: true}, nil
+	return CacheResponse{ColorValue: value, Hit: true}, nil
 }
 
-func (r Redis) Set(key string, colors []string, counts []int) error {
+func (r Redis) Set(key string, value ColorValue) error {
 	if !strings.Contains(key, "_") {
 		return fmt.Errorf("invalid redis key format")
 	}
 
 	ctx := context.Background()
 
-	val, err := r.parseRedisIn(colors, counts)
+	val, err := encodeColorValue(value)
 	if err != nil {
 		return fmt.Errorf("error parsing redis input: %w", err)
 	}
 
-	resInt := r.client.Set(ctx, key, val, time.Duration(ttlDays*24)*time.Hour)
+	resInt := r.client.Set(ctx, key, val, colorTTL)
 	if resInt.Err() != nil || resInt.Val() == "" {
 		return fmt.Errorf("error setting to redis: %w", resInt.Err()) // If logs show nil err, then val == ""
 	}
 	return nil
 }
 
-func (r Redis) parseRedisOut(vals string) ([]string, []int, error) {
-	colors := []string{}
-	counts := []int{}
+// Key prefixes for session, write progress and list snapshot values, keeping them apart from the filmID_version colour keys
+const (
+	sessionPrefix       = "session:"
+	writeProgressPrefix = "write:"
+	listSnapshotPrefix  = "snapshot:"
+)
 
-	slc := strings.Split(vals, ",")
+// Stores an (already encrypted) session value under the given id, expiring after ttl
+func (r Redis) SetSession(id string, val []byte, ttl time.Duration) error {
+	if id == "" {
+		return fmt.Errorf("invalid session id")
+	}
+	if err := r.setBytes(sessionPrefix+id, val, ttl); err != nil {
+		return fmt.Errorf("error setting session to redis: %w", err)
+	}
+	return nil
+}
 
-	if len(slc) != 3 {
-		return nil, nil, fmt.Errorf("unexpected length of value fetched from redis; length %d", len(slc))
+// Gets a session value from redis. If the session doesn't exist or has expired, found is returned as false
+func (r Redis) GetSession(id string) (val []byte, found bool, err error) {
+	val, found, err = r.getBytes(sessionPrefix + id)
+	if err != nil {
+		return nil, false, fmt.Errorf("error getting session from redis: %w", err)
 	}
+	return val, found, nil
+}
 
-	for _, c := range slc {
-		count, err := strconv.Atoi(c[7:])
-		if err != nil || count < 0 {
-			return nil, nil, fmt.Errorf("invalid count post-conversion: %w", err)
-		}
+// Removes a session from redis. Deleting a nonexistent session is not an error
+func (r Redis) DeleteSession(id string) error {
+	if err := r.client.Del(context.TODO(), sessionPrefix+id).Err(); err != nil {
+		return fmt.Errorf("error deleting session from redis: %w", err)
+	}
+	return nil
+}
 
-		if c[:7] != "XXXXXXX" {
-			colors = append(colors, c[:7])
-		}
-		if count != 0 {
-			counts = append(counts, count)
-		}
+// Stores the progress of a list write under the given key, expiring after ttl
+func (r Redis) SetWriteProgress(key string, val []byte, ttl time.Duration) error {
+	if key == "" {
+		return fmt.Errorf("invalid write progress key")
+	}
+	if err := r.setBytes(writeProgressPrefix+key, val, ttl); err != nil {
+		return fmt.Errorf("error setting write progress to redis: %w", err)
+	}
+	return nil
+}
 
+// Gets the progress of a list write from redis. If there is none, found is returned as false
+func (r Redis) GetWriteProgress(key string) (val []byte, found bool, err error) {
+	val, found, err = r.getBytes(writeProgressPrefix + key)
+	if err != nil {
+		return nil, false, fmt.Errorf("error getting write progress from redis: %w", err)
 	}
-	return colors, counts, nil
+	return val, found, nil
 }
 
-func (r Redis) parseRedisIn(colors []string, counts []int) (string, error) {
-	slc := []string{"", "", ""}
-	for i := 0; i < 3; i++ {
-		if i >= min(len(colors), len(counts)) {
-			slc[i] = "XXXXXXX0000"
-			continue
-		}
-		if len(colors[i]) != 7 { // used during development
-			return "", fmt.Errorf("weird color string length: %d", len(colors[i]))
-		}
-		if counts[i] > 9999 || counts[i] < 0 {
-			return "", fmt.Errorf("color count is out of range: %d", counts[i])
-		}
+// Removes the progress of a list write from redis. Deleting nonexistent progress is not an error
+func (r Redis) DeleteWriteProgress(key string) error {
+	if err := r.client.Del(context.TODO(), writeProgressPrefix+key).Err(); err != nil {
+		return fmt.Errorf("error deleting write progress from redis: %w", err)
+	}
+	return nil
+}
 
-		slc[i] = colors[i] + fmt.Sprintf("%04d", counts[i])
+// Stores the order of a list before it was sorted under the given key, expiring after ttl
+func (r Redis) SetListSnapshot(key string, val []byte, ttl time.Duration) error {
+	if key == "" {
+		return fmt.Errorf("invalid list snapshot key")
+	}
+	if err := r.setBytes(listSnapshotPrefix+key, val, ttl); err != nil {
+		return fmt.Errorf("error setting list snapshot to redis: %w", err)
+	}
+	return nil
+}
+
+// Gets the order of a list before it was sorted from redis. If there is none, found is returned as false
+func (r Redis) GetListSnapshot(key string) (val []byte, found bool, err error) {
+	val, found, err = r.getBytes(listSnapshotPrefix + key)
+	if err != nil {
+		return nil, false, fmt.Errorf("error getting list snapshot from redis: %w", err)
+	}
+	return val, found, nil
+}
+
+// Removes a list snapshot from redis. Deleting a nonexistent snapshot is not an error
+func (r Redis) DeleteListSnapshot(key string) error {
+	if err := r.client.Del(context.TODO(), listSnapshotPrefix+key).Err(); err != nil {
+		return fmt.Errorf("error deleting list snapshot from redis: %w", err)
+	}
+	return nil
+}
+
+func (r Redis) setBytes(key string, val []byte, ttl time.Duration) error {
+	resInt := r.client.Set(context.TODO(), key, val, ttl)
+	if resInt.Err() != nil || resInt.Val() == "" {
+		return resInt.Err() // If logs show nil err, then val == ""
+	}
+	return nil
+}
+
+func (r Redis) getBytes(key string) ([]byte, bool, error) {
+	val, err := r.client.Get(context.TODO(), key).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, false, nil
+		}
+		return nil, false, err
 	}
-	return strings.Join(slc, ","), nil
+	return val, true, nil
 }
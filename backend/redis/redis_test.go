@@ -2,18 +2,45 @@ package redis
 
 import (
 	"fmt"
-	"reflect"
 	"testing"
+	"time"
 
 	"github.com/alicebob/miniredis/v2"
 	"github.com/stretchr/testify/assert"
 )
 
+// Connects to the given miniredis
+func newTestClient(t *testing.T, s *miniredis.Miniredis) Redis {
+	rc, err := New(fmt.Sprintf("redis://%s", s.Addr()))
+	if err != nil {
+		t.Fatalf("failed to connect to redis: %v", err)
+	}
+	t.Cleanup(func() { rc.Close() })
+	return rc
+}
+
+func TestNew(t *testing.T) {
+	assert := assert.New(t)
+	s := miniredis.RunT(t)
+
+	rc, err := New(fmt.Sprintf("redis://%s", s.Addr()))
+	assert.Nil(err)
+	assert.Nil(rc.Close())
+
+	_, err = New("not a url")
+	assert.ErrorContains(err, "invalid redis url")
+
+	addr := s.Addr()
+	s.Close()
+	_, err = New(fmt.Sprintf("redis://%s", addr))
+	assert.ErrorContains(err, "failed to reach redis")
+}
+
 // This test does most of the testing of formatting / basic redis interaction
 func TestGetSet(t *testing.T) {
 	assert := assert.New(t)
 	s := miniredis.RunT(t)
-	rc := New(fmt.Sprintf("redis://%s", s.Addr()))
+	rc := newTestClient(t, s)
 
 	testCases := []struct {
 		name       string
@@ -41,7 +68,7 @@ func TestGetSet(t *testing.T) {
 			colors:    []string{"badFormat", "terribleFormat", "youCan'tExpectMeToBelieveThisIsAHexColour"},
 			counts:    []int{3000, 200, 10},
 			hit:       false,
-			errStrSet: "weird color string length",
+			errStrSet: "weird color string",
 			errStrGet: "",
 		},
 		{
@@ -54,24 +81,31 @@ func TestGetSet(t *testing.T) {
 			errStrGet: "",
 		},
 		{
-			name:      "Fail due to bad count format - > 9999",
+			name:      "Successful Set and Get - counts above 9999",
 			key:       "testKey_4",
 			colors:    []string{"#FF0000", "#00FF00", "#0000FF"},
 			counts:    []int{10000, 1, 1},
+			hit:       true,
+			errStrSet: "",
+			errStrGet: "",
+		},
+		{
+			name:      "Fail due to mismatched colors and counts",
+			key:       "testKey_5",
+			colors:    []string{"#FF0000"},
+			counts:    []int{3000, 200, 10},
 			hit:       false,
-			errStrSet: "color count is out of range",
+			errStrSet: "length of colors and counts do not match",
 			errStrGet: "",
 		},
 		{
-			name:       "Successfully set and get, insufficient length",
-			key:        "testKey_5",
-			colors:     []string{"#FF0000"},
-			counts:     []int{3000, 200, 10},
-			hit:        true,
-			colors_out: []string{"#FF0000"},
-			counts_out: []int{3000},
-			errStrSet:  "",
-			errStrGet:  "",
+			name:      "Successful Set and Get - more than 3 colors",
+			key:       "testKey_5",
+			colors:    []string{"#FF0000", "#00FF00", "#0000FF", "#FFFFFF", "#000000"},
+			counts:    []int{3000, 200, 10, 5, 1},
+			hit:       true,
+			errStrSet: "",
+			errStrGet: "",
 		},
 		{
 			name:      "Successfully overwrite a key, and get",
@@ -101,7 +135,7 @@ func TestGetSet(t *testing.T) {
 			tc.counts_out = tc.counts
 		}
 
-		err := rc.Set(tc.key, tc.colors, tc.counts)
+		err := rc.Set(tc.key, ColorValue{Colors: tc.colors, Counts: tc.counts, Algorithm: "test"})
 		if tc.errStrSet != "" {
 			assert.ErrorContains(err, tc.errStrSet)
 		} else {
@@ -117,6 +151,8 @@ func TestGetSet(t *testing.T) {
 			assert.Nil(err)
 			assert.Equal(tc.colors_out, res.Colors)
 			assert.Equal(tc.counts_out, res.Counts)
+			assert.Equal("test", res.Algorithm)
+			assert.WithinDuration(time.Now(), res.CachedAt, time.Minute)
 		}
 
 	}
@@ -126,13 +162,17 @@ func TestGetSet(t *testing.T) {
 func TestGetSetBatch(t *testing.T) {
 	assert := assert.New(t)
 	s := miniredis.RunT(t)
-	rc := New(fmt.Sprintf("redis://%s", s.Addr()))
+	rc := newTestClient(t, s)
+
+	cachedAt := time.Unix(1760000000, 0)
+	red := ColorValue{Colors: []string{"#FF0000", "#FF0000", "#FF0000"}, Counts: []int{1000, 100, 10}, Algorithm: "test", CachedAt: cachedAt}
+	green := ColorValue{Colors: []string{"#00FF00", "#00FF00", "#00FF00"}, Counts: []int{2000, 200, 20}, Algorithm: "test", CachedAt: cachedAt}
+	blue := ColorValue{Colors: []string{"#0000FF", "#0000FF", "#0000FF"}, Counts: []int{3000, 300, 30}, Algorithm: "test", CachedAt: cachedAt}
 
 	testCases := []struct {
 		name      string
 		keys      []string
-		colors    [][]string
-		counts    [][]int
+		values    []ColorValue
 		keys_out  []string
 		res       map[string]CacheResponse
 		errStrSet string
@@ -141,12 +181,11 @@ func TestGetSetBatch(t *testing.T) {
 		{
 			name:   "Successful Set and Get",
 			keys:   []string{"testKey1_1", "testKey1_2", "testKey1_3"},
-			colors: [][]string{{"#FF0000", "#FF0000", "#FF0000"}, {"#00FF00", "#00FF00", "#00FF00"}, {"#0000FF", "#0000FF", "#0000FF"}},
-			counts: [][]int{{1000, 100, 10}, {2000, 200, 20}, {3000, 300, 30}},
+			values: []ColorValue{red, green, blue},
 			res: map[string]CacheResponse{
-				"testKey1_1": {Colors: []string{"#FF0000", "#FF0000", "#FF0000"}, Counts: []int{1000, 100, 10}, Hit: true},
-				"testKey1_2": {Colors: []string{"#00FF00", "#00FF00", "#00FF00"}, Counts: []int{2000, 200, 20}, Hit: true},
-				"testKey1_3": {Colors: []string{"#0000FF", "#0000FF", "#0000FF"}, Counts: []int{3000, 300, 30}, Hit: true},
+				"testKey1_1": {ColorValue: red, Hit: true},
+				"testKey1_2": {ColorValue: green, Hit: true},
+				"testKey1_3": {ColorValue: blue, Hit: true},
 			},
 			errStrSet: "",
 			errStrGet: "",
@@ -154,17 +193,25 @@ func TestGetSetBatch(t *testing.T) {
 		{
 			name:     "Successful Set and Get - try get some nonexistent keys",
 			keys:     []string{"testKey2_1", "testKey2_2", "testKey2_3"},
-			colors:   [][]string{{"#FF0000", "#FF0000", "#FF0000"}, {"#00FF00", "#00FF00", "#00FF00"}, {"#0000FF", "#0000FF", "#0000FF"}},
-			counts:   [][]int{{1000, 100, 10}, {2000, 200, 20}, {3000, 300, 30}},
+			values:   []ColorValue{red, green, blue},
 			keys_out: []string{"testKey2_1", "badKey1", "badKey2"},
 			res: map[string]CacheResponse{
-				"testKey2_1": {Colors: []string{"#FF0000", "#FF0000", "#FF0000"}, Counts: []int{1000, 100, 10}, Hit: true},
+				"testKey2_1": {ColorValue: red, Hit: true},
 				"badKey1":    {Hit: false},
 				"badKey2":    {Hit: false},
 			},
 			errStrSet: "",
 			errStrGet: "",
 		},
+		{
+			name:      "Fail due to mismatched keys and values",
+			keys:      []string{"testKey3_1", "testKey3_2"},
+			values:    []ColorValue{red},
+			keys_out:  []string{"testKey3_1"},
+			res:       map[string]CacheResponse{"testKey3_1": {Hit: false}},
+			errStrSet: "length of keys and values do not match",
+			errStrGet: "",
+		},
 	}
 
 	for _, tc := range testCases {
@@ -173,7 +220,7 @@ func TestGetSetBatch(t *testing.T) {
 		}
 
 		var err error
-		err = rc.SetBatch(tc.keys, tc.colors, tc.counts)
+		err = rc.SetBatch(tc.keys, tc.values)
 		if tc.errStrSet != "" {
 			assert.ErrorContains(err, tc.errStrSet)
 		} else {
@@ -185,10 +232,172 @@ func TestGetSetBatch(t *testing.T) {
 			assert.ErrorContains(err, tc.errStrGet)
 		} else {
 			assert.Nil(err)
-			assert.True(reflect.DeepEqual(tc.res, res))
-			fmt.Println(tc.res)
-			fmt.Println(res)
+			assert.Equal(tc.res, res, tc.name)
 		}
 
 	}
 }
+
+// Batches expire like single values do, and are set all-or-nothing
+func TestSetBatchTTLAndAtomicity(t *testing.T) {
+	assert := assert.New(t)
+	s := miniredis.RunT(t)
+	rc := newTestClient(t, s)
+
+	red := ColorValue{Colors: []string{"#FF0000"}, Counts: []int{10}}
+	bad := ColorValue{Colors: []string{"red"}, Counts: []int{10}}
+
+	assert.Nil(rc.Set("single_1", red))
+	assert.Nil(rc.SetBatch([]string{"batch_1", "batch_2"}, []ColorValue{red, red}))
+	for _, key := range []string{"single_1", "batch_1", "batch_2"} {
+		assert.Equal(30*24*time.Hour, s.TTL(key), key)
+	}
+
+	// Setting a key again restarts its expiry
+	s.FastForward(29 * 24 * time.Hour)
+	assert.Nil(rc.SetBatch([]string{"batch_1"}, []ColorValue{red}))
+	assert.Equal(30*24*time.Hour, s.TTL("batch_1"))
+	s.FastForward(2 * 24 * time.Hour)
+	assert.True(s.Exists("batch_1"))
+	assert.False(s.Exists("batch_2"))
+
+	// An invalid value or key fails the whole batch
+	assert.ErrorContains(rc.SetBatch([]string{"partial_1", "partial_2"}, []ColorValue{red, bad}), "weird color string")
+	assert.ErrorContains(rc.SetBatch([]string{"partial_1", "partial2"}, []ColorValue{red, red}), "invalid redis key format")
+	assert.False(s.Exists("partial_1"))
+	assert.False(s.Exists("partial_2"))
+
+	// As does redis failing
+	s.SetError("LOADING redis is loading the dataset in memory")
+	assert.ErrorContains(rc.SetBatch([]string{"partial_1", "partial_2"}, []ColorValue{red, red}), "error batch-setting to redis")
+	s.SetError("")
+	assert.False(s.Exists("partial_1"))
+	assert.False(s.Exists("partial_2"))
+
+	// An empty batch is a no-op
+	assert.Nil(rc.SetBatch(nil, nil))
+}
+
+// Values written in the legacy fixed-width format are still read, and values in an unknown format are misses
+func TestColorValueFormats(t *testing.T) {
+	assert := assert.New(t)
+	s := miniredis.RunT(t)
+	rc := newTestClient(t, s)
+
+	s.Set("legacy_1", "#FF00003000,#00FF000200,XXXXXXX0000")
+	s.Set("legacy_2", "#FF00000000,#00FF000100,#0000FF0100")
+	s.Set("future_1", `{"v":99,"colors":["#FF0000"],"counts":[1]}`)
+	s.Set("broken_1", `{"v":1,"colors":["#FF0000"],"counts":[]}`)
+
+	res, err := rc.Get("legacy_1")
+	assert.Nil(err)
+	assert.Equal(CacheResponse{ColorValue: ColorValue{Colors: []string{"#FF0000", "#00FF00"}, Counts: []int{3000, 200}}, Hit: true}, res)
+
+	// A colour with a zero count keeps its count, so that colours and counts still line up
+	res, err = rc.Get("legacy_2")
+	assert.Nil(err)
+	assert.Equal([]string{"#FF0000", "#00FF00", "#0000FF"}, res.Colors)
+	assert.Equal([]int{0, 100, 100}, res.Counts)
+
+	_, err = rc.Get("future_1")
+	assert.ErrorContains(err, "unsupported color value version 99")
+	_, err = rc.Get("broken_1")
+	assert.ErrorContains(err, "length of colors and counts do not match")
+
+	batch, err := rc.GetBatch([]string{"legacy_1", "future_1", "broken_1"})
+	assert.Nil(err)
+	assert.True(batch["legacy_1"].Hit)
+	assert.False(batch["future_1"].Hit)
+	assert.False(batch["broken_1"].Hit)
+
+	// New values are written in the versioned format, whatever was there before
+	assert.Nil(rc.Set("legacy_1", ColorValue{Colors: []string{"#0000FF"}, Counts: []int{10}, Algorithm: "test", CachedAt: time.Unix(1760000000, 0)}))
+	val, _ := s.Get("legacy_1")
+	assert.JSONEq(`{"v":1,"colors":["#0000FF"],"counts":[10],"algorithm":"test","cachedAt":1760000000}`, val)
+}
+
+func TestSessions(t *testing.T) {
+	assert := assert.New(t)
+	s := miniredis.RunT(t)
+	rc := newTestClient(t, s)
+
+	_, found, err := rc.GetSession("missing")
+	assert.Nil(err)
+	assert.False(found)
+
+	assert.ErrorContains(rc.SetSession("", []byte("value"), time.Hour), "invalid session id")
+
+	assert.Nil(rc.SetSession("abc", []byte("value"), time.Hour))
+	assert.Equal(time.Hour, s.TTL("session:abc"))
+
+	val, found, err := rc.GetSession("abc")
+	assert.Nil(err)
+	assert.True(found)
+	assert.Equal([]byte("value"), val)
+
+	// Sessions must not be readable as colour cache entries, or vice versa
+	res, err := rc.Get("abc")
+	assert.Nil(err)
+	assert.False(res.Hit)
+
+	s.FastForward(2 * time.Hour)
+	_, found, err = rc.GetSession("abc")
+	assert.Nil(err)
+	assert.False(found)
+
+	assert.Nil(rc.SetSession("def", []byte("value"), time.Hour))
+	assert.Nil(rc.DeleteSession("def"))
+	assert.Nil(rc.DeleteSession("def"))
+	_, found, _ = rc.GetSession("def")
+	assert.False(found)
+}
+
+func TestWriteProgress(t *testing.T) {
+	assert := assert.New(t)
+	s := miniredis.RunT(t)
+	rc := newTestClient(t, s)
+
+	assert.ErrorContains(rc.SetWriteProgress("", []byte("value"), time.Hour), "invalid write progress key")
+
+	assert.Nil(rc.SetWriteProgress("user:list", []byte("value"), time.Hour))
+	assert.Equal(time.Hour, s.TTL("write:user:list"))
+
+	val, found, err := rc.GetWriteProgress("user:list")
+	assert.Nil(err)
+	assert.True(found)
+	assert.Equal([]byte("value"), val)
+
+	// Write progress and sessions don't share keys
+	_, found, _ = rc.GetSession("user:list")
+	assert.False(found)
+
+	assert.Nil(rc.DeleteWriteProgress("user:list"))
+	_, found, err = rc.GetWriteProgress("user:list")
+	assert.Nil(err)
+	assert.False(found)
+}
+
+func TestListSnapshots(t *testing.T) {
+	assert := assert.New(t)
+	s := miniredis.RunT(t)
+	rc := newTestClient(t, s)
+
+	assert.ErrorContains(rc.SetListSnapshot("", []byte("value"), time.Hour), "invalid list snapshot key")
+
+	assert.Nil(rc.SetListSnapshot("user:list", []byte("value"), time.Hour))
+	assert.Equal(time.Hour, s.TTL("snapshot:user:list"))
+
+	val, found, err := rc.GetListSnapshot("user:list")
+	assert.Nil(err)
+	assert.True(found)
+	assert.Equal([]byte("value"), val)
+
+	// Snapshots and write progress don't share keys
+	_, found, _ = rc.GetWriteProgress("user:list")
+	assert.False(found)
+
+	assert.Nil(rc.DeleteListSnapshot("user:list"))
+	_, found, err = rc.GetListSnapshot("user:list")
+	assert.Nil(err)
+	assert.False(found)
+}
@@ -0,0 +1,89 @@
+package colorboxd
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// How long a session is kept in memory after it was last read or written. Only sessions in use while redis
+// goes down are needed, so this is kept short.
+const sessionFallbackTTL = time.Hour
+
+// SessionStore stores encrypted sessions by id. redis.Redis is the store sessions are kept in.
+type SessionStore interface {
+	GetSession(id string) (val []byte, found bool, err error)
+	SetSession(id string, val []byte, ttl time.Duration) error
+	DeleteSession(id string) error
+}
+
+// fallbackSessionStore is a SessionStore that keeps the sessions recently used from a remote store (redis)
+// in memory, and serves them while the remote store can't be reached. Sessions live in the same redis as the
+// colour cache by default, so without this a redis outage signs every user out, rather than leaving them
+// able to sort lists without the colour cache.
+type fallbackSessionStore struct {
+	remote SessionStore
+
+	mu    sync.Mutex
+	local map[string]fallbackSession
+}
+
+type fallbackSession struct {
+	val     []byte
+	expires time.Time
+}
+
+func newFallbackSessionStore(remote SessionStore) *fallbackSessionStore {
+	return &fallbackSessionStore{remote: remote, local: make(map[string]fallbackSession)}
+}
+
+func (f *fallbackSessionStore) GetSession(id string) ([]byte, bool, error) {
+	val, found, err := f.remote.GetSession(id)
+	if err != nil {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if s, ok := f.local[id]; ok && time.Now().Before(s.expires) {
+			slog.Default().Warn("serving session from memory", "err", err)
+			return s.val, true, nil
+		}
+		return nil, false, err
+	}
+
+	if found {
+		f.keep(id, val)
+	} else {
+		f.forget(id)
+	}
+	return val, found, nil
+}
+
+// Keeps the session in memory even if it can't be stored remotely, although the error is still returned
+func (f *fallbackSessionStore) SetSession(id string, val []byte, ttl time.Duration) error {
+	f.keep(id, val)
+	return f.remote.SetSession(id, val, ttl)
+}
+
+func (f *fallbackSessionStore) DeleteSession(id string) error {
+	f.forget(id)
+	return f.remote.DeleteSession(id)
+}
+
+// Stores a session in memory, and drops any that have expired
+func (f *fallbackSessionStore) keep(id string, val []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	for key, s := range f.local {
+		if now.After(s.expires) {
+			delete(f.local, key)
+		}
+	}
+	f.local[id] = fallbackSession{val: val, expires: now.Add(sessionFallbackTTL)}
+}
+
+func (f *fallbackSessionStore) forget(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.local, id)
+}
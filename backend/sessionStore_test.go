@@ -0,0 +1,45 @@
+package colorboxd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFallbackSessionStore(t *testing.T) {
+	assert := assert.New(t)
+	s := setTestEnv(t, "")
+	remote := newTestRedis(t)
+	store := newFallbackSessionStore(remote)
+
+	assert.Nil(store.SetSession("kept", []byte("a"), time.Hour))
+	assert.Nil(remote.SetSession("read", []byte("b"), time.Hour))
+	assert.Nil(remote.SetSession("gone", []byte("c"), time.Hour))
+	for _, id := range []string{"read", "gone"} {
+		_, found, err := store.GetSession(id)
+		assert.Nil(err)
+		assert.True(found)
+	}
+
+	// Sessions deleted elsewhere, or signed out of, are forgotten
+	s.Del("session:gone")
+	_, found, err := store.GetSession("gone")
+	assert.Nil(err)
+	assert.False(found)
+	assert.Nil(store.SetSession("signedOut", []byte("d"), time.Hour))
+	assert.Nil(store.DeleteSession("signedOut"))
+
+	// While redis is down, the sessions in memory are served
+	s.Close()
+	for id, expected := range map[string]string{"kept": "a", "read": "b"} {
+		val, found, err := store.GetSession(id)
+		assert.Nil(err)
+		assert.True(found)
+		assert.Equal(expected, string(val))
+	}
+	for _, id := range []string{"gone", "signedOut", "unknown"} {
+		_, _, err := store.GetSession(id)
+		assert.Error(err, id)
+	}
+}
@@ -0,0 +1,270 @@
+package colorboxd
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dsantos747/letterboxd_hue_sort/backend/letterboxd"
+)
+
+const (
+	sessionCookieName = "colorboxd_session"
+	sessionTTL        = 30 * 24 * time.Hour
+	tokenExpiryMargin = 30 * time.Second // Refresh the access token slightly before Letterboxd expires it
+)
+
+// Returned when a request carries no session cookie, or the session it refers to no longer exists
+var errNoSession = errors.New("no valid session; please sign in again")
+
+// A user's server-side session. It is only ever stored encrypted, and is referred
+// to by the client through an opaque id held in an HttpOnly cookie.
+type Session struct {
+	UserID       string    `json:"userId"`
+	Username     string    `json:"username"`
+	GivenName    string    `json:"givenName"`
+	TokenType    string    `json:"tokenType"`
+	AccessToken  string    `json:"accessToken"`
+	RefreshToken string    `json:"refreshToken"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+}
+
+// Creates a new session for the authorised member, stores it and sets the session cookie on w
+func createSession(w http.ResponseWriter, store SessionStore, token *AccessTokenResponse, member *Member) (*Session, error) {
+	idBytes := make([]byte, 32)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, fmt.Errorf("failed to generate session id: %w", err)
+	}
+	id := base64.RawURLEncoding.EncodeToString(idBytes)
+
+	session := &Session{
+		UserID:       member.ID,
+		Username:     member.Username,
+		GivenName:    member.GivenName,
+		TokenType:    token.TokenType,
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		ExpiresAt:    time.Now().Add(time.Duration(token.ExpiresIn) * time.Second),
+	}
+	if err := saveSession(store, id, session); err != nil {
+		return nil, err
+	}
+
+	http.SetCookie(w, sessionCookie(id, int(sessionTTL.Seconds())))
+
+	return session, nil
+}
+
+func sessionCookie(id string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode, // The frontend is served from a different site to the backend
+	}
+}
+
+// Deletes the session referred to by the request's session cookie, if there is one, and expires the cookie on w
+func deleteSession(w http.ResponseWriter, r *http.Request, store SessionStore) error {
+	if cookie, err := r.Cookie(sessionCookieName); err == nil && cookie.Value != "" {
+		if err := store.DeleteSession(cookie.Value); err != nil {
+			return err
+		}
+	}
+
+	http.SetCookie(w, sessionCookie("", -1))
+	return nil
+}
+
+// Looks up the session referred to by the request's session cookie. Returns errNoSession if there isn't one.
+func getSession(r *http.Request, store SessionStore) (string, *Session, error) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return "", nil, errNoSession
+	}
+
+	val, found, err := store.GetSession(cookie.Value)
+	if err != nil {
+		return "", nil, err
+	}
+	if !found {
+		return "", nil, errNoSession
+	}
+
+	session, err := decryptSession(val)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read session: %w", err)
+	}
+
+	return cookie.Value, session, nil
+}
+
+// Encrypts and stores a session, resetting its expiry
+func saveSession(store SessionStore, id string, session *Session) error {
+	val, err := encryptSession(session)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt session: %w", err)
+	}
+	return store.SetSession(id, val, sessionTTL)
+}
+
+// Resolves the session for a request and returns a Letterboxd client authorised with its access token.
+// The access token is refreshed (and the session updated) if it has expired.
+func sessionClient(r *http.Request, store SessionStore) (*letterboxd.Client, *Session, error) {
+	id, session, err := getSession(r, store)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tokens := &sessionTokens{store: store, id: id, session: session}
+	return letterboxd.New(os.Getenv("LBOXD_BASEURL"), tokens), session, nil
+}
+
+// sessionTokens is a letterboxd.TokenSource which serves the access token stored in
+// a session, refreshing it through the auth/token endpoint once it has expired.
+type sessionTokens struct {
+	mu      sync.Mutex
+	store   SessionStore
+	id      string
+	session *Session
+}
+
+func (t *sessionTokens) Token(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if time.Until(t.session.ExpiresAt) > tokenExpiryMargin {
+		return t.session.AccessToken, nil
+	}
+
+	if err := refreshSession(ctx, t.store, t.id, t.session); err != nil {
+		return "", err
+	}
+	return t.session.AccessToken, nil
+}
+
+// Exchanges the session's refresh token for a new access token, and stores the updated session
+func refreshSession(ctx context.Context, store SessionStore, id string, session *Session) error {
+	token, err := refreshAccessToken(ctx, session.RefreshToken)
+	if err != nil {
+		return fmt.Errorf("could not refresh access token: %w", err)
+	}
+	if token.AccessToken == "" {
+		return fmt.Errorf("could not generate access token from session refresh token")
+	}
+
+	session.AccessToken = token.AccessToken
+	session.TokenType = token.TokenType
+	session.ExpiresAt = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	// Letterboxd may not rotate the refresh token; if not, the old one remains valid
+	if token.RefreshToken != "" {
+		session.RefreshToken = token.RefreshToken
+	}
+
+	return saveSession(store, id, session)
+}
+
+// Reads the 32-byte AES-256 session encryption key from the base64-encoded SESSION_KEY env variable
+func sessionKey() ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(os.Getenv("SESSION_KEY"))
+	if err != nil {
+		return nil, fmt.Errorf("SESSION_KEY is not valid base64: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("SESSION_KEY must decode to 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+func sessionCipher() (cipher.AEAD, error) {
+	key, err := sessionKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Serialises and encrypts a session with AES-GCM. The random nonce is prepended to the ciphertext.
+func encryptSession(session *Session) ([]byte, error) {
+	aead, err := sessionCipher()
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := json.Marshal(session)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func decryptSession(val []byte) (*Session, error) {
+	aead, err := sessionCipher()
+	if err != nil {
+		return nil, err
+	}
+	if len(val) < aead.NonceSize() {
+		return nil, fmt.Errorf("session value too short")
+	}
+
+	nonce, ciphertext := val[:aead.NonceSize()], val[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var session Session
+	if err := json.Unmarshal(plaintext, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// Rejects a state-changing request sent from a site other than the frontend. Browsers send the session cookie
+// with cross-site requests (it is SameSite=None), but always say where such a request came from in its Origin.
+func checkRequestOrigin(r *http.Request) error {
+	if origin := r.Header.Get("Origin"); origin != "" && origin != os.Getenv("BASE_URL") {
+		return newAPIError(http.StatusForbidden, ErrCodeForbidden, "requests from this origin are not allowed", nil)
+	}
+	return nil
+}
+
+// As checkRequestOrigin, and also requires a JSON body. A cross-site form can't send one, and a cross-site
+// fetch can only do so after a CORS preflight, which only the frontend's origin passes.
+func checkJSONRequest(r *http.Request) error {
+	if err := checkRequestOrigin(r); err != nil {
+		return err
+	}
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		return newAPIError(http.StatusUnsupportedMediaType, ErrCodeUnsupportedMediaType, "request body must be application/json", nil)
+	}
+	return nil
+}
+
+// Writes the appropriate error response for a failure to resolve a session
+func returnSessionError(w http.ResponseWriter, err error) {
+	ReturnError(w, apiErrorOr(err, http.StatusInternalServerError, ErrCodeInternal, "could not resolve session"))
+}
@@ -0,0 +1,99 @@
+package colorboxd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dsantos747/letterboxd_hue_sort/backend/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+// Connects to the miniredis started by setTestEnv
+func newTestRedis(t *testing.T) redis.Redis {
+	rc, err := redis.New(os.Getenv("REDIS_URL"))
+	if err != nil {
+		t.Fatalf("failed to connect to redis: %v", err)
+	}
+	t.Cleanup(func() { rc.Close() })
+	return rc
+}
+
+// Stores a session and returns the cookie referring to it. Requires setTestEnv.
+func newTestSession(t *testing.T, session *Session) *http.Cookie {
+	w := httptest.NewRecorder()
+	if session.ExpiresAt.IsZero() {
+		session.ExpiresAt = time.Now().Add(time.Hour)
+	}
+	_, err := createSession(w, newTestRedis(t), &AccessTokenResponse{}, &Member{})
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	cookie := w.Result().Cookies()[0]
+	if err := saveSession(newTestRedis(t), cookie.Value, session); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	return cookie
+}
+
+func TestSessionEncryption(t *testing.T) {
+	assert := assert.New(t)
+	s := setTestEnv(t, "")
+
+	session := &Session{UserID: "67W7X", AccessToken: "secretToken", RefreshToken: "secretRefresh", ExpiresAt: time.Now().Round(0)}
+	cookie := newTestSession(t, session)
+
+	// Nothing readable is stored at rest
+	stored, err := s.Get("session:" + cookie.Value)
+	assert.Nil(err)
+	assert.NotContains(stored, "secretToken")
+	assert.NotContains(stored, "secretRefresh")
+
+	decrypted, err := decryptSession([]byte(stored))
+	assert.Nil(err)
+	assert.True(session.ExpiresAt.Equal(decrypted.ExpiresAt))
+	decrypted.ExpiresAt = session.ExpiresAt
+	assert.Equal(session, decrypted)
+
+	// Tampered values and values encrypted under a different key are rejected
+	tampered := []byte(stored)
+	tampered[len(tampered)-1] ^= 1
+	_, err = decryptSession(tampered)
+	assert.NotNil(err)
+
+	t.Setenv("SESSION_KEY", "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=")
+	_, err = decryptSession([]byte(stored))
+	assert.NotNil(err)
+
+	t.Setenv("SESSION_KEY", "tooShort")
+	_, err = encryptSession(session)
+	assert.ErrorContains(err, "SESSION_KEY")
+}
+
+func TestSessionClientRefreshesExpiredToken(t *testing.T) {
+	assert := assert.New(t)
+	srv := authServer(t)
+	setTestEnv(t, srv.URL)
+
+	cookie := newTestSession(t, &Session{UserID: "67W7X", AccessToken: "oldToken", RefreshToken: "goodRefresh", ExpiresAt: time.Now().Add(-time.Minute)})
+	r := httptest.NewRequest("GET", "/api/v1/lists", nil)
+	r.AddCookie(cookie)
+
+	lc, _, err := sessionClient(r, newTestRedis(t))
+	assert.Nil(err)
+	token, err := lc.Tokens.Token(context.Background())
+	assert.Nil(err)
+	assert.Equal("newToken", token)
+
+	// The refreshed token is persisted to the session
+	_, session, err := getSession(r, newTestRedis(t))
+	assert.Nil(err)
+	assert.Equal("newToken", session.AccessToken)
+	assert.True(session.ExpiresAt.After(time.Now()))
+
+	_, _, err = sessionClient(httptest.NewRequest("GET", "/api/v1/lists", nil), newTestRedis(t))
+	assert.ErrorIs(err, errNoSession)
+}
@@ -170,3 +170,56 @@ func AlgoBRBW2(colors []Color) int {
 
 	return score
 }
+
+// Chooses the first colour which satisfies OkLCh chroma and count ratio
+// If no match, return nil.
+func dominantChromaticColour(colors []Color, chroma float64) *Color {
+	for i, c := range colors {
+		ratio := float64(colors[0].count) / float64(c.count)
+		if _, cC, _ := c.rgb.OkLch(); cC >= chroma && ratio <= 1.5 {
+			return &colors[i]
+		}
+	}
+	return nil
+}
+
+const (
+	okLChBands    = 8    // The number of hue bands used by the perceptual hue sorts
+	neutralChroma = 0.05 // The OkLCh chroma below which a colour is treated as neutral
+)
+
+// Ranks a colour by its OkLCh hue, grouped into bands. Within each band, colours are ordered by
+// perceptual lightness, alternating direction between bands so that neighbouring bands meet smoothly.
+func okLChBandRank(color Color, bands int) int {
+	l, _, h := color.rgb.OkLch()
+
+	band := int((h / 360) * float64(bands))
+	light := int(l * 1000)
+	if band%2 == 1 {
+		light = 1000 - light
+	}
+
+	return 10000*band + light // Factor of 10000 to keep lightness within its band
+}
+
+// Sorts by the OkLCh hue of the most dominant colour, in lightness-ordered bands. OkLCh is perceptually
+// uniform, so posters that look alike end up together more often than when sorting by HSL hue.
+func AlgoOkLChHue(colors []Color, bands int) int {
+	return okLChBandRank(colors[0], bands)
+}
+
+// Sorts as AlgoOkLChHue, using the most common sufficiently chromatic colour. Posters with no such colour
+// are near-neutral, and are pushed to the ends: darks first (black to grey), lights last (grey to white).
+func AlgoOkLChNeutralEnds(colors []Color, bands int) int {
+	color := dominantChromaticColour(colors, neutralChroma)
+	if color != nil {
+		return okLChBandRank(*color, bands)
+	}
+
+	l, _, _ := colors[0].rgb.OkLch()
+	light := int(l * 1000)
+	if l < 0.5 {
+		return -10000 + light
+	}
+	return 10000*(bands+1) + light
+}
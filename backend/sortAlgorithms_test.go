@@ -0,0 +1,53 @@
+package colorboxd
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Orders the fixtures by rank, returning their names
+func rankFixtures(fixtures map[string][]string, rank func([]Color) int) []string {
+	names := []string{}
+	for name := range fixtures {
+		names = append(names, name)
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		return rank(parseColors(fixtures[a], []int{3000, 2500, 500})) - rank(parseColors(fixtures[b], []int{3000, 2500, 500}))
+	})
+	return names
+}
+
+func TestAlgoOkLChNeutralEnds(t *testing.T) {
+	fixtures := map[string][]string{
+		"black":     {"#050505", "#0A0A0A", "#101010"},
+		"darkGrey":  {"#404040", "#383838", "#101010"},
+		"lightGrey": {"#B0B0B0", "#A8A8A8", "#F0F0F0"},
+		"white":     {"#FAFAFA", "#F5F5F5", "#FFFFFF"},
+		"red":       {"#D42A2A", "#101010", "#FAFAFA"},
+		"darkRed":   {"#101010", "#8A1010", "#FAFAFA"}, // dark poster, but the red is common enough
+		"blue":      {"#2A5BD4", "#101010", "#FAFAFA"},
+	}
+
+	order := rankFixtures(fixtures, func(c []Color) int { return AlgoOkLChNeutralEnds(c, 8) })
+	assert.Equal(t, []string{"black", "darkGrey"}, order[:2])
+	assert.Equal(t, []string{"lightGrey", "white"}, order[5:])
+	assert.ElementsMatch(t, []string{"red", "darkRed", "blue"}, order[2:5])
+	assert.Less(t, slices.Index(order, "red"), slices.Index(order, "blue"))
+}
+
+func TestAlgoOkLChHueBands(t *testing.T) {
+	// With 8 bands of 45 degrees: the orange (hue 50) is in band 1, the teals (hue 195) in band 4, and the
+	// blues (hue 249) in band 5. Lightness runs dark to light in even bands, and light to dark in odd ones.
+	fixtures := map[string][]string{
+		"orange":    {"#E07020"},
+		"darkTeal":  {"#208080"},
+		"lightTeal": {"#60C0C0"},
+		"darkBlue":  {"#0A3050"},
+		"lightBlue": {"#80B0E0"},
+	}
+
+	order := rankFixtures(fixtures, func(c []Color) int { return AlgoOkLChHue(c, okLChBands) })
+	assert.Equal(t, []string{"orange", "darkTeal", "lightTeal", "lightBlue", "darkBlue"}, order)
+}
@@ -0,0 +1,78 @@
+package colorboxd
+
+import (
+	"cmp"
+	"slices"
+)
+
+// Letterboxd lists are shown as a grid; these are the common widths of that grid.
+var gridColumnCounts = []int{5, 7}
+
+// The poster properties used to place it in the grid
+type gridKey struct {
+	index     int
+	hue       float64 // -1 for dark neutral posters, 361 for light neutral posters
+	lightness float64
+}
+
+// Orders the posters so that, laid out row by row in a grid with the given number of columns, the grid
+// reads as a 2D gradient: hue changes across the columns, and lightness changes down the rows (light to
+// dark). Near-neutral posters are placed in the outermost columns, darks on the left and lights on the right.
+//
+// Returns each poster's position in the list, i.e. its row-major position in the grid.
+func OrderGrid(colors [][]Color, columns int) []int {
+	n := len(colors)
+	if n == 0 || columns < 1 {
+		return make([]int, n)
+	}
+
+	keys := make([]gridKey, n)
+	for i, c := range colors {
+		keys[i] = newGridKey(i, c)
+	}
+
+	// Split the posters into columns by hue. The grid is filled row by row, so if the last row is partial,
+	// only the first lastRow columns have a poster in it.
+	slices.SortFunc(keys, func(a, b gridKey) int {
+		return cmp.Or(cmp.Compare(a.hue, b.hue), cmp.Compare(b.lightness, a.lightness), cmp.Compare(a.index, b.index))
+	})
+	rows := (n + columns - 1) / columns
+	lastRow := n - (rows-1)*columns
+
+	positions := make([]int, n)
+	start := 0
+	for col := range columns {
+		height := rows
+		if col >= lastRow {
+			height = rows - 1
+		}
+		column := keys[start : start+height]
+		start += height
+
+		// Then order each column from light (top) to dark (bottom)
+		slices.SortFunc(column, func(a, b gridKey) int {
+			return cmp.Or(cmp.Compare(b.lightness, a.lightness), cmp.Compare(a.hue, b.hue), cmp.Compare(a.index, b.index))
+		})
+		for row, key := range column {
+			positions[key.index] = row*columns + col
+		}
+	}
+
+	return positions
+}
+
+func newGridKey(index int, colors []Color) gridKey {
+	l, _, _ := colors[0].rgb.OkLch()
+
+	color := dominantChromaticColour(colors, neutralChroma)
+	if color == nil {
+		hue := 361.0
+		if l < 0.5 {
+			hue = -1
+		}
+		return gridKey{index: index, hue: hue, lightness: l}
+	}
+
+	_, _, h := color.rgb.OkLch()
+	return gridKey{index: index, hue: h, lightness: l}
+}
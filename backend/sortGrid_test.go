@@ -0,0 +1,57 @@
+package colorboxd
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderGrid(t *testing.T) {
+	assert := assert.New(t)
+	r := rand.New(rand.NewSource(3))
+
+	for _, tc := range []struct{ n, columns int }{{35, 7}, {33, 7}, {23, 5}, {3, 5}, {1, 7}} {
+		var colors [][]Color
+		for range tc.n {
+			colors = append(colors, parseColors([]string{fmt.Sprintf("#%06X", r.Intn(0xFFFFFF))}, []int{1}))
+		}
+
+		positions := OrderGrid(colors, tc.columns)
+
+		// Positions fill the grid from the start, with no gaps
+		sorted := slices.Clone(positions)
+		slices.Sort(sorted)
+		for i, pos := range sorted {
+			assert.Equal(i, pos)
+		}
+
+		grid := make([]gridKey, tc.n)
+		for i, pos := range positions {
+			grid[pos] = newGridKey(i, colors[i])
+		}
+		for pos, key := range grid {
+			// Lightness decreases down each column
+			if below := pos + tc.columns; below < tc.n {
+				assert.GreaterOrEqual(key.lightness, grid[below].lightness, "n=%d, position %d", tc.n, pos)
+			}
+			// Every poster in a column has a hue no greater than every poster in the next column
+			if col := pos % tc.columns; col < tc.columns-1 {
+				for next := col + 1; next < tc.n; next += tc.columns {
+					assert.LessOrEqual(key.hue, grid[next].hue, "n=%d, position %d", tc.n, pos)
+				}
+			}
+		}
+	}
+}
+
+func TestOrderGridNeutralsAtEdges(t *testing.T) {
+	colors := [][]Color{
+		parseColors([]string{"#FAFAFA"}, []int{1}),
+		parseColors([]string{"#D42A2A"}, []int{1}),
+		parseColors([]string{"#0A0A0A"}, []int{1}),
+	}
+	assert.Equal(t, []int{2, 1, 0}, OrderGrid(colors, 3))
+}
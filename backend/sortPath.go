@@ -0,0 +1,179 @@
+package colorboxd
+
+import (
+	"math"
+)
+
+// The maximum number of 2-opt improvement passes made over the path. Each pass is O(n²),
+// so this bounds the work on very large lists; most lists converge well before it.
+const smoothMaxPasses = 20
+
+// Lists up to this size have their pairwise distances precomputed (as float32, so 36MB at the limit).
+// Larger lists compute distances on demand, which is slower but keeps memory use flat.
+const smoothMatrixLimit = 3000
+
+// A colour in OkLab space, with the share of the poster it covers
+type weightedLab struct {
+	l, a, b float64
+	weight  float64
+}
+
+// Converts a poster's dominant colours to OkLab, weighting each by its share of the counted pixels
+func posterLab(colors []Color) []weightedLab {
+	total := 0
+	for _, c := range colors {
+		total += c.count
+	}
+
+	labs := make([]weightedLab, len(colors))
+	for i, c := range colors {
+		l, a, b := c.rgb.OkLab()
+		weight := 1 / float64(len(colors))
+		if total > 0 {
+			weight = float64(c.count) / float64(total)
+		}
+		labs[i] = weightedLab{l, a, b, weight}
+	}
+	return labs
+}
+
+// The perceptual distance between two posters. Each colour of one poster is matched with the nearest
+// colour of the other, and the distances are averaged by how much of the poster that colour covers.
+// This is done in both directions, so the distance is symmetric.
+func posterDistance(p, q []weightedLab) float64 {
+	nearest := func(from, to []weightedLab) float64 {
+		sum := 0.0
+		for _, x := range from {
+			closest := math.Inf(1)
+			for _, y := range to {
+				d := math.Sqrt((x.l-y.l)*(x.l-y.l) + (x.a-y.a)*(x.a-y.a) + (x.b-y.b)*(x.b-y.b))
+				closest = math.Min(closest, d)
+			}
+			sum += x.weight * closest
+		}
+		return sum
+	}
+	return (nearest(p, q) + nearest(q, p)) / 2
+}
+
+// Orders the posters so that the total colour distance between neighbours is as small as possible, i.e.
+// an approximate solution to the travelling salesman path problem. The path starts at the darkest poster,
+// is built by greedily visiting the nearest unvisited poster, then improved with 2-opt moves.
+//
+// Returns each poster's position in the ordering.
+func OrderSmoothGradient(colors [][]Color) []int {
+	n := len(colors)
+	if n == 0 {
+		return []int{}
+	}
+
+	points := make([][]weightedLab, n)
+	for i, c := range colors {
+		points[i] = posterLab(c)
+	}
+	dist := distanceFunc(points)
+
+	path := greedyPath(n, dist, darkestPoster(points))
+	twoOpt(path, dist, smoothMaxPasses)
+
+	positions := make([]int, n)
+	for pos, i := range path {
+		positions[i] = pos
+	}
+	return positions
+}
+
+// Returns a function giving the distance between two posters, backed by a precomputed matrix if the list is small enough
+func distanceFunc(points [][]weightedLab) func(int, int) float64 {
+	n := len(points)
+	if n > smoothMatrixLimit {
+		return func(i, j int) float64 {
+			return posterDistance(points[i], points[j])
+		}
+	}
+
+	matrix := make([]float32, n*n)
+	for i := range n {
+		for j := i + 1; j < n; j++ {
+			d := float32(posterDistance(points[i], points[j]))
+			matrix[i*n+j], matrix[j*n+i] = d, d
+		}
+	}
+	return func(i, j int) float64 {
+		return float64(matrix[i*n+j])
+	}
+}
+
+// Returns the index of the poster with the lowest weighted OkLab lightness
+func darkestPoster(points [][]weightedLab) int {
+	darkest, minL := 0, math.Inf(1)
+	for i, p := range points {
+		l := 0.0
+		for _, c := range p {
+			l += c.weight * c.l
+		}
+		if l < minL {
+			darkest, minL = i, l
+		}
+	}
+	return darkest
+}
+
+// Builds a path through all n points by repeatedly stepping to the nearest unvisited point.
+// Ties are broken by index, so the path is deterministic.
+func greedyPath(n int, dist func(int, int) float64, start int) []int {
+	visited := make([]bool, n)
+	path := make([]int, 0, n)
+
+	curr := start
+	for range n {
+		visited[curr] = true
+		path = append(path, curr)
+
+		next, nextDist := -1, math.Inf(1)
+		for j := range n {
+			if !visited[j] {
+				if d := dist(curr, j); d < nextDist {
+					next, nextDist = j, d
+				}
+			}
+		}
+		curr = next
+	}
+	return path
+}
+
+// Improves an open path in place by reversing segments wherever doing so shortens it, until no
+// improving reversal remains or maxPasses passes have been made. The first point is kept fixed.
+func twoOpt(path []int, dist func(int, int) float64, maxPasses int) {
+	n := len(path)
+	const epsilon = 1e-12 // Ignore improvements within floating point error, which could otherwise cycle
+
+	for pass := 0; pass < maxPasses; pass++ {
+		improved := false
+
+		for i := 1; i < n-1; i++ {
+			for j := i + 1; j < n; j++ {
+				// Reversing path[i..j] replaces edges (i-1, i) and (j, j+1) with (i-1, j) and (i, j+1).
+				// When j is the last point, there is no (j, j+1) edge.
+				before := dist(path[i-1], path[i])
+				after := dist(path[i-1], path[j])
+				if j < n-1 {
+					before += dist(path[j], path[j+1])
+					after += dist(path[i], path[j+1])
+				}
+
+				if after < before-epsilon {
+					for a, b := i, j; a < b; a, b = a+1, b-1 {
+						path[a], path[b] = path[b], path[a]
+					}
+					improved = true
+				}
+			}
+		}
+
+		if !improved {
+			return
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package colorboxd
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func pathLength(colors [][]Color, order []int) float64 {
+	total := 0.0
+	for k := 1; k < len(order); k++ {
+		total += posterDistance(posterLab(colors[order[k-1]]), posterLab(colors[order[k]]))
+	}
+	return total
+}
+
+// Converts positions (as returned by an Order function) into the sequence of poster indices
+func positionsToOrder(positions []int) []int {
+	order := make([]int, len(positions))
+	for i, pos := range positions {
+		order[pos] = i
+	}
+	return order
+}
+
+// A shuffled greyscale ramp should come back as a ramp, from black to white
+func TestOrderSmoothGradientRamp(t *testing.T) {
+	assert := assert.New(t)
+
+	var colors [][]Color
+	for v := 0; v < 256; v += 8 {
+		colors = append(colors, parseColors([]string{fmt.Sprintf("#%02X%02X%02X", v, v, v)}, []int{1}))
+	}
+	r := rand.New(rand.NewSource(1))
+	r.Shuffle(len(colors), func(i, j int) { colors[i], colors[j] = colors[j], colors[i] })
+
+	order := positionsToOrder(OrderSmoothGradient(colors))
+	assert.True(slices.IsSortedFunc(order, func(a, b int) int {
+		return int(colors[a][0].l*1000) - int(colors[b][0].l*1000)
+	}))
+}
+
+func TestOrderSmoothGradientImprovesGreedy(t *testing.T) {
+	assert := assert.New(t)
+	r := rand.New(rand.NewSource(2))
+
+	var colors [][]Color
+	for range 200 {
+		hexes := []string{}
+		for range 3 {
+			hexes = append(hexes, fmt.Sprintf("#%06X", r.Intn(0xFFFFFF)))
+		}
+		colors = append(colors, parseColors(hexes, []int{3000, 1500, 500}))
+	}
+
+	positions := OrderSmoothGradient(colors)
+
+	// Every poster appears exactly once
+	sorted := slices.Clone(positions)
+	slices.Sort(sorted)
+	for i, pos := range sorted {
+		assert.Equal(i, pos)
+	}
+
+	points := make([][]weightedLab, len(colors))
+	for i, c := range colors {
+		points[i] = posterLab(c)
+	}
+	greedy := greedyPath(len(colors), func(i, j int) float64 { return posterDistance(points[i], points[j]) }, darkestPoster(points))
+	assert.Less(pathLength(colors, positionsToOrder(positions)), pathLength(colors, greedy))
+
+	// And the ordering is deterministic
+	assert.Equal(positions, OrderSmoothGradient(colors))
+	assert.Empty(OrderSmoothGradient(nil))
+}
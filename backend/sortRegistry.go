@@ -0,0 +1,145 @@
+package colorboxd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A named sort algorithm. Rank maps a poster's dominant colours to a single value; posters are sorted by ascending rank.
+//
+// Algorithms which need to see the whole list at once set Order instead of Rank. Order receives the dominant
+// colours of every poster, and returns each poster's position in the sorted list.
+type SortAlgorithm struct {
+	Name        string                `json:"id"` // Used as the sortMethod in requests, and as the key in SortVals
+	Label       string                `json:"name"`
+	Description string                `json:"description"`
+	Parameters  map[string]float64    `json:"parameters,omitempty"`
+	Global      bool                  `json:"global"`      // Set on registration if the algorithm uses Order
+	FixedLayout bool                  `json:"fixedLayout"` // The order can't be rotated by an offset or reversed without breaking it
+	Rank        func([]Color) int     `json:"-"`
+	Order       func([][]Color) []int `json:"-"`
+}
+
+// All available sort algorithms, in the order they are presented to the user
+var sortAlgorithms []SortAlgorithm
+
+// The algorithm used when no sort method is specified
+const defaultSortMethod = "hue"
+
+// Checks that the order produced by a sort method can be rotated by offset and reversed as requested.
+// Returns a bad request error if not. The method is assumed to be valid.
+func checkSortArrangement(method string, offset int, reverse bool) error {
+	if method == "" {
+		method = defaultSortMethod
+	}
+	algo, _ := lookupSortAlgorithm(method)
+	if algo.FixedLayout && (offset != 0 || reverse) {
+		return badRequest(fmt.Sprintf("the %q sort can't be offset or reversed", algo.Name))
+	}
+	return nil
+}
+
+// Adds a sort algorithm to the registry. Panics if the name is empty or already registered.
+func registerSortAlgorithm(algo SortAlgorithm) {
+	if algo.Name == "" || (algo.Rank == nil) == (algo.Order == nil) {
+		panic("sort algorithm must have a name and exactly one of a rank or order function")
+	}
+	algo.Global = algo.Order != nil
+	if _, ok := lookupSortAlgorithm(algo.Name); ok {
+		panic(fmt.Sprintf("sort algorithm %q registered twice", algo.Name))
+	}
+	sortAlgorithms = append(sortAlgorithms, algo)
+}
+
+// Finds a registered sort algorithm by name. Matching is case-insensitive.
+func lookupSortAlgorithm(name string) (SortAlgorithm, bool) {
+	for _, algo := range sortAlgorithms {
+		if strings.EqualFold(algo.Name, name) {
+			return algo, true
+		}
+	}
+	return SortAlgorithm{}, false
+}
+
+func init() {
+	registerSortAlgorithm(SortAlgorithm{
+		Name:        "hue",
+		Label:       "Hue",
+		Description: "Sorts by the hue of the most dominant colour",
+		Rank:        AlgoHue,
+	})
+	registerSortAlgorithm(SortAlgorithm{
+		Name:        "lum",
+		Label:       "Luminosity",
+		Description: "Sorts by the luminosity of the most dominant colour",
+		Rank:        AlgoLuminosity,
+	})
+	registerSortAlgorithm(SortAlgorithm{
+		Name:        "brightDomHue",
+		Label:       "Bright Dominant Hue",
+		Description: "Sorts by the hue of the most common vivid colour, provided it is common enough relative to the most dominant colour",
+		Parameters:  map[string]float64{"vividness": 0.25},
+		Rank:        AlgoBrightDominantHue,
+	})
+	for _, reps := range []int{8, 12} {
+		registerSortAlgorithm(SortAlgorithm{
+			Name:        fmt.Sprintf("inverseStep_%d", reps),
+			Label:       fmt.Sprintf("Inverse Step (%d)", reps),
+			Description: "Groups the most dominant colour into hue bands, alternating the direction of luminosity within each band",
+			Parameters:  map[string]float64{"reps": float64(reps)},
+			Rank:        func(colors []Color) int { return AlgoInverseStep(colors, reps) },
+		})
+	}
+	for _, reps := range []int{8, 12} {
+		registerSortAlgorithm(SortAlgorithm{
+			Name:        fmt.Sprintf("inverseStep2_%d", reps),
+			Label:       fmt.Sprintf("Inverse Step v2 (%d)", reps),
+			Description: "As Inverse Step, but using the most common vivid colour rather than the most dominant",
+			Parameters:  map[string]float64{"reps": float64(reps), "vividness": 0.25},
+			Rank:        func(colors []Color) int { return AlgoInverseStepV2(colors, reps) },
+		})
+	}
+	registerSortAlgorithm(SortAlgorithm{
+		Name:        "BRBW1",
+		Label:       "BRBW 1",
+		Description: "Blacks first, then colours from red to blue, then whites",
+		Rank:        AlgoBRBW1,
+	})
+	registerSortAlgorithm(SortAlgorithm{
+		Name:        "BRBW2",
+		Label:       "BRBW 2",
+		Description: "As BRBW 1, with a lower vividness threshold so fewer posters fall into the black and white zones",
+		Rank:        AlgoBRBW2,
+	})
+	registerSortAlgorithm(SortAlgorithm{
+		Name:        "oklch",
+		Label:       "Perceptual Hue",
+		Description: "Groups the most dominant colour into perceptual (OkLCh) hue bands, ordered by lightness within each band",
+		Parameters:  map[string]float64{"bands": okLChBands},
+		Rank:        func(colors []Color) int { return AlgoOkLChHue(colors, okLChBands) },
+	})
+	registerSortAlgorithm(SortAlgorithm{
+		Name:        "oklchNeutralEnds",
+		Label:       "Perceptual Hue (neutrals at ends)",
+		Description: "As Perceptual Hue, using the most common colourful colour, and moving near-neutral posters to the ends: darks first, lights last",
+		Parameters:  map[string]float64{"bands": okLChBands, "chroma": neutralChroma},
+		Rank:        func(colors []Color) int { return AlgoOkLChNeutralEnds(colors, okLChBands) },
+	})
+	registerSortAlgorithm(SortAlgorithm{
+		Name:        "smooth",
+		Label:       "Smooth Gradient",
+		Description: "Orders the whole list so that each poster's colours are as close as possible to its neighbours', starting from the darkest poster",
+		Parameters:  map[string]float64{"maxPasses": smoothMaxPasses},
+		Order:       OrderSmoothGradient,
+	})
+	for _, columns := range gridColumnCounts {
+		registerSortAlgorithm(SortAlgorithm{
+			Name:        fmt.Sprintf("grid_%d", columns),
+			Label:       fmt.Sprintf("Grid (%d wide)", columns),
+			Description: "Lays the list out as a 2D gradient when viewed as a grid: hue changes across the columns, and lightness down the rows",
+			Parameters:  map[string]float64{"columns": float64(columns), "chroma": neutralChroma},
+			FixedLayout: true, // Rotating or reversing the order would break up its rows and columns
+			Order:       func(colors [][]Color) []int { return OrderGrid(colors, columns) },
+		})
+	}
+}
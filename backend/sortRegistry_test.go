@@ -0,0 +1,119 @@
+package colorboxd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetSorts(t *testing.T) {
+	assert := assert.New(t)
+	t.Setenv("ENVIRONMENT", "test")
+
+	w := httptest.NewRecorder()
+	GetSorts(w, httptest.NewRequest("GET", "/api/v1/sorts", nil))
+
+	var res []struct {
+		ID          string             `json:"id"`
+		Name        string             `json:"name"`
+		Description string             `json:"description"`
+		Parameters  map[string]float64 `json:"parameters"`
+		FixedLayout bool               `json:"fixedLayout"`
+	}
+	assert.Nil(json.NewDecoder(w.Body).Decode(&res))
+	assert.Len(res, len(sortAlgorithms))
+	assert.Equal("hue", res[0].ID)
+	for i, algo := range res {
+		assert.Equal(sortAlgorithms[i].Name, algo.ID)
+		assert.NotEmpty(algo.Name)
+		assert.NotEmpty(algo.Description)
+		assert.Equal(strings.HasPrefix(algo.ID, "grid_"), algo.FixedLayout, algo.ID)
+	}
+}
+
+func TestRegisterSortAlgorithm(t *testing.T) {
+	assert.Panics(t, func() {
+		registerSortAlgorithm(SortAlgorithm{Name: "Hue", Rank: AlgoHue})
+	}, "duplicate names, case-insensitively")
+	assert.Panics(t, func() {
+		registerSortAlgorithm(SortAlgorithm{Name: "noRank"})
+	})
+
+	algo, ok := lookupSortAlgorithm("HUE")
+	assert.True(t, ok)
+	assert.Equal(t, "hue", algo.Name)
+}
+
+// Every registered sort must be populated by assignListRankings, and must actually distinguish between
+// posters. This guards against an algorithm being selectable but silently sorting everything by zero.
+func TestAssignListRankingsPopulatesAllSorts(t *testing.T) {
+	assert := assert.New(t)
+
+	fixtures := [][]string{
+		{"#1A1A1A", "#D42A2A", "#F2F2F2"}, // dark poster, vivid red accent
+		{"#F5F0E6", "#2A5BD4", "#101010"}, // light poster, vivid blue accent
+		{"#2AD45B", "#0A0A0A", "#FAFAFA"}, // vivid green
+		{"#7F7F7F", "#D4A02A", "#3A3A3A"}, // grey poster, vivid orange accent
+		{"#6A2AD4", "#D42AA0", "#202020"}, // vivid purple and pink
+	}
+	var entries []Entry
+	for i, hexes := range fixtures {
+		entries = append(entries, Entry{ListPosition: i, ImageInfo: ImageInfo{Colors: parseColors(hexes, []int{3000, 2500, 500})}})
+	}
+
+	ranked, err := assignListRankings(&entries)
+	assert.Nil(err)
+
+	for _, algo := range sortAlgorithms {
+		seen := map[int]bool{}
+		for _, e := range *ranked {
+			val, ok := e.SortVals[algo.Name]
+			assert.True(ok, "%s not populated", algo.Name)
+			seen[val] = true
+		}
+		assert.Greater(len(seen), 1, "%s ranks every poster the same", algo.Name)
+
+		_, err := resolveSortFunction(algo.Name)
+		assert.Nil(err, algo.Name)
+	}
+
+	// The bright dominant hue of a dark poster with a vivid red accent is the red
+	assert.Equal(AlgoHue(parseColors([]string{"#D42A2A"}, []int{1})), (*ranked)[0].SortVals["brightDomHue"])
+
+	_, err = assignListRankings(&[]Entry{{Name: "No colours"}})
+	assert.ErrorContains(err, "No colours")
+}
+
+// Posters of the same colour are ranked by their list position, whatever order they were processed in
+func TestAssignListRankingsDeterministic(t *testing.T) {
+	assert := assert.New(t)
+
+	newEntries := func(positions ...int) []Entry {
+		var entries []Entry
+		for _, pos := range positions {
+			hexes := []string{"#D42A2A", "#1A1A1A", "#F2F2F2"}
+			if pos%2 == 1 {
+				hexes = []string{"#2A5BD4", "#1A1A1A", "#F2F2F2"}
+			}
+			entries = append(entries, Entry{FilmID: fmt.Sprint(pos), ListPosition: pos, ImageInfo: ImageInfo{Colors: parseColors(hexes, []int{3000, 2500, 500})}})
+		}
+		return entries
+	}
+	rankings := func(entries []Entry) map[string]SortVals {
+		ranked, err := assignListRankings(&entries)
+		assert.Nil(err)
+		res := map[string]SortVals{}
+		for _, e := range *ranked {
+			res[e.FilmID] = e.SortVals
+		}
+		return res
+	}
+
+	expected := rankings(newEntries(0, 1, 2, 3, 4, 5))
+	assert.Equal(expected, rankings(newEntries(5, 3, 1, 4, 2, 0)))
+	assert.Equal(expected, rankings(newEntries(2, 0, 5, 1, 3, 4)))
+}
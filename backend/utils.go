@@ -7,6 +7,8 @@ import (
 	"os"
 	"time"
 
+	"github.com/dsantos747/letterboxd_hue_sort/backend/letterboxd"
+
 	"github.com/joho/godotenv"
 )
 
@@ -23,10 +25,14 @@ func LoadEnv() error {
 	return nil
 }
 
-// ReturnError sends a http error back to the ResponseWriter w
-func ReturnError(w http.ResponseWriter, message string, statusCode int) {
-	w.Header().Set("Content-Type", "text/plain")
-	http.Error(w, message, statusCode)
+// Creates a Letterboxd API client authorised with the given access token.
+// An empty token creates an unauthorised client, suitable for the auth/token exchange.
+func newLetterboxdClient(token string) *letterboxd.Client {
+	var tokens letterboxd.TokenSource
+	if token != "" {
+		tokens = letterboxd.StaticToken(token)
+	}
+	return letterboxd.New(os.Getenv("LBOXD_BASEURL"), tokens)
 }
 
 var HTTPclient = &http.Client{
@@ -34,7 +40,7 @@ var HTTPclient = &http.Client{
 }
 
 // Makes an HTTP request of the required method to the specified endpoint.
-// If response code is >= 400 , returns an error with response.Status
+// If response code is >= 400, returns a *letterboxd.UpstreamError describing it, having closed the response body
 func MakeHTTPRequest(method, endpoint string, body io.Reader, headers map[string]string) (*http.Response, error) {
 	// Prepare the request
 	req, err := http.NewRequest(method, endpoint, body)
@@ -51,8 +57,7 @@ func MakeHTTPRequest(method, endpoint string, body io.Reader, headers map[string
 		return nil, err
 	}
 	if response.StatusCode >= 400 {
-		err = fmt.Errorf("%s", response.Status)
-		return nil, err
+		return nil, letterboxd.NewUpstreamError(response)
 	}
 
 	return response, nil
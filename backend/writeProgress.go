@@ -0,0 +1,105 @@
+package colorboxd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/dsantos747/letterboxd_hue_sort/backend/letterboxd"
+	"github.com/dsantos747/letterboxd_hue_sort/backend/redis"
+)
+
+const (
+	writeBatchSize   = 100            // The most moves sent to Letterboxd in a single PATCH request
+	writeProgressTTL = 24 * time.Hour // How long an unfinished write can be resumed for
+)
+
+// The progress of writing a sorted order to a list. The plan is sent to Letterboxd in batches, and the
+// progress is saved after each, so that a write that fails part-way through can be resumed.
+type WriteProgress struct {
+	ListID  string            `json:"listId"`
+	Version int               `json:"version"` // The list version the next batch is made against
+	Entries []listUpdateEntry `json:"entries"` // Every move in the plan, including those already applied
+	Done    int               `json:"done"`    // How many of the moves have been applied
+}
+
+func newWriteProgress(listID string, request ListUpdateRequest) *WriteProgress {
+	return &WriteProgress{ListID: listID, Version: request.Version, Entries: request.Entries}
+}
+
+// Write progress and list snapshots are kept per user, so that one user can't resume or undo another's write
+func userListKey(userID, listID string) string {
+	return userID + ":" + listID
+}
+
+// Loads the unfinished write of a user's list, if there is one
+func loadWriteProgress(rc redis.Redis, userID, listID string) (*WriteProgress, bool, error) {
+	val, found, err := rc.GetWriteProgress(userListKey(userID, listID))
+	if err != nil || !found {
+		return nil, false, err
+	}
+
+	var progress WriteProgress
+	if err := json.Unmarshal(val, &progress); err != nil {
+		return nil, false, fmt.Errorf("failed to decode write progress: %w", err)
+	}
+	return &progress, true, nil
+}
+
+func saveWriteProgress(rc redis.Redis, userID string, progress *WriteProgress) error {
+	val, err := json.Marshal(progress)
+	if err != nil {
+		return fmt.Errorf("failed to encode write progress: %w", err)
+	}
+	return rc.SetWriteProgress(userListKey(userID, progress.ListID), val, writeProgressTTL)
+}
+
+// Applies the remaining moves of a write to the list in batches, saving progress after each one. Once every
+// batch has been applied the saved progress is removed; if a batch fails, the write can be resumed from it.
+func writeListInBatches(ctx context.Context, lc *letterboxd.Client, rc redis.Redis, userID string, progress *WriteProgress) (*[]string, error) {
+	l := slog.Default()
+
+	// Progress is only a convenience for resuming; failing to save it shouldn't stop the write itself
+	save := func() {
+		if err := saveWriteProgress(rc, userID, progress); err != nil {
+			l.Warn("failed to save write progress", "list", progress.ListID, "err", err)
+		}
+	}
+	remove := func() {
+		if err := rc.DeleteWriteProgress(userListKey(userID, progress.ListID)); err != nil {
+			l.Warn("failed to remove write progress", "list", progress.ListID, "err", err)
+		}
+	}
+	save()
+
+	for progress.Done < len(progress.Entries) {
+		batch := progress.Entries[progress.Done:min(progress.Done+writeBatchSize, len(progress.Entries))]
+
+		version, err := writeListSorting(ctx, lc, progress.ListID, ListUpdateRequest{Version: progress.Version, Entries: batch})
+		if errors.Is(err, errListVersionConflict) {
+			// The rest of the plan was made against an order the list is no longer in, so it can't be resumed
+			remove()
+			return nil, fmt.Errorf("%d of %d moves were applied before the list changed: %w", progress.Done, len(progress.Entries), err)
+		}
+		if err != nil {
+			apiErr := apiErrorOr(err, http.StatusBadGateway, ErrCodeLetterboxdError, "")
+			message := fmt.Sprintf("%d of %d moves were applied before the write failed, and it can be resumed", progress.Done, len(progress.Entries))
+			resumable := newAPIError(apiErr.Status, apiErr.Code, message, err)
+			resumable.RetryAfter = apiErr.RetryAfter
+			return nil, resumable
+		}
+
+		progress.Version = version
+		progress.Done += len(batch)
+		save()
+	}
+
+	remove()
+
+	message := []string{"List updated successfully"}
+	return &message, nil
+}
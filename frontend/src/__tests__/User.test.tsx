@@ -5,15 +5,22 @@ import { List, ListSummary, UserToken } from '../lib/definitions';
 import { MemoryRouter } from 'react-router-dom';
 
 const mockNavigate = jest.fn();
+jest.mock('../actions/actions', () => ({
+  ...jest.requireActual('../actions/actions'),
+  GetSorts: () =>
+    Promise.resolve([
+      { id: 'hue', name: 'Hue', description: 'Sorts by hue', global: false, fixedLayout: false },
+      { id: 'grid_5', name: 'Grid (5 wide)', description: 'Sorts into a grid', global: true, fixedLayout: true },
+    ]),
+}));
+
 jest.mock('react-router-dom', () => ({
   ...jest.requireActual('react-router-dom'),
   useNavigate: () => mockNavigate,
 }));
 
 const testToken: UserToken = {
-  Token: 'token',
   TokenExpiresIn: 1000,
-  TokenRefresh: 'refresh',
   TokenType: 'type',
   UserGivenName: 'John',
   UserId: '123',
@@ -1,5 +1,5 @@
 import Cookies from 'js-cookie';
-import { EntryWithImage, List, ListSummary, SortModeType, UserToken } from '../lib/definitions';
+import { EntryWithImage, ErrorResponse, List, ListSummary, SortOption, UserToken } from '../lib/definitions';
 
 const BACKEND_URL = process.env.REACT_APP_BACKEND_URL;
 
@@ -21,7 +21,8 @@ async function GetAccessTokenAndUser(authCode: string, refresh = false): Promise
   if (!response.ok) {
     let errorText;
     try {
-      errorText = await response.text();
+      const body: ErrorResponse = await response.json();
+      errorText = body.error.message;
     } catch (error) {
       errorText = `Error code: ${response.status}; Message: ${response.statusText}`;
     }
@@ -33,10 +34,10 @@ async function GetAccessTokenAndUser(authCode: string, refresh = false): Promise
   return data;
 }
 
-async function GetLists(accessToken: string, userId: string, refresh = false): Promise<ListSummary[]> {
+async function GetLists(refresh = false): Promise<ListSummary[]> {
   const cacheMode: RequestCache = refresh ? 'reload' : 'default';
 
-  const response = await fetch(`${BACKEND_URL}/api/v1/lists?accessToken=${encodeURIComponent(accessToken)}&userId=${userId}`, {
+  const response = await fetch(`${BACKEND_URL}/api/v1/lists`, {
     method: 'GET',
     cache: cacheMode,
     credentials: 'include',
@@ -44,7 +45,8 @@ async function GetLists(accessToken: string, userId: string, refresh = false): P
   if (!response.ok) {
     let errorText;
     try {
-      errorText = await response.text();
+      const body: ErrorResponse = await response.json();
+      errorText = body.error.message;
     } catch (error) {
       errorText = `Error code: ${response.status}; Message: ${response.statusText}`;
     }
@@ -55,16 +57,44 @@ async function GetLists(accessToken: string, userId: string, refresh = false): P
   return data;
 }
 
+let sortsCache: SortOption[] | null = null;
+
+// Fetches the sort methods offered by the backend. They only change when the backend is deployed, so are fetched once.
+async function GetSorts(): Promise<SortOption[]> {
+  if (sortsCache) {
+    return sortsCache;
+  }
+
+  const response = await fetch(`${BACKEND_URL}/api/v1/sorts`, {
+    method: 'GET',
+    cache: 'default',
+  });
+  if (!response.ok) {
+    let errorText;
+    try {
+      const body: ErrorResponse = await response.json();
+      errorText = body.error.message;
+    } catch (error) {
+      errorText = `Error code: ${response.status}; Message: ${response.statusText}`;
+    }
+    throw new Error(errorText);
+  }
+
+  const data: SortOption[] = await response.json();
+  sortsCache = data;
+  return data;
+}
+
 let listCache: Record<string, List> = {};
 
 function ClearListCache() {
   listCache = {};
 }
 
-async function SortList(accessToken: string, listSummary: ListSummary, refresh = false): Promise<List> {
+async function SortList(listSummary: ListSummary, refresh = false): Promise<List> {
   const cacheMode: RequestCache = refresh || !listCache[listSummary.id] ? 'reload' : 'default';
 
-  const response = await fetch(`${BACKEND_URL}/api/v1/sort?accessToken=${encodeURIComponent(accessToken)}&listId=${listSummary.id}`, {
+  const response = await fetch(`${BACKEND_URL}/api/v1/sort?listId=${listSummary.id}`, {
     method: 'GET',
     cache: cacheMode,
     credentials: 'include',
@@ -72,7 +102,8 @@ async function SortList(accessToken: string, listSummary: ListSummary, refresh =
   if (!response.ok) {
     let errorText;
     try {
-      errorText = await response.text();
+      const body: ErrorResponse = await response.json();
+      errorText = body.error.message;
     } catch (error) {
       errorText = `Error code: ${response.status}; Message: ${response.statusText}`;
     }
@@ -91,16 +122,15 @@ async function SortList(accessToken: string, listSummary: ListSummary, refresh =
 }
 
 async function WriteSortedList(
-  accessToken: string,
   list: List,
   offset: number,
-  sortMethod: SortModeType['sortMode']['id'],
+  sortMethod: SortOption['id'],
   reverse: boolean,
   refresh = false
 ): Promise<string[]> {
   const cacheMode: RequestCache = refresh ? 'reload' : 'default';
 
-  const requestBody = { accessToken, list, offset, sortMethod, reverse };
+  const requestBody = { listId: list.id, offset, sortMethod, reverse };
 
   const response = await fetch(`${BACKEND_URL}/api/v1/write`, {
     method: 'POST',
@@ -114,7 +144,8 @@ async function WriteSortedList(
   if (!response.ok) {
     let errorText;
     try {
-      errorText = await response.text();
+      const body: ErrorResponse = await response.json();
+      errorText = body.error.message;
     } catch (error) {
       errorText = `Error code: ${response.status}; Message: ${response.statusText}`;
     }
@@ -125,4 +156,21 @@ async function WriteSortedList(
   return message;
 }
 
-export { GetAccessTokenAndUser, GetLists, SortList, WriteSortedList, ClearListCache };
+// Ends the server-side session and expires the session cookie. The local copy of the user's details is
+// removed even if the backend can't be reached, so the UI always signs out.
+async function SignOut(): Promise<void> {
+  Cookies.remove('userToken');
+  ClearListCache();
+
+  try {
+    await fetch(`${BACKEND_URL}/api/v1/auth/signout`, {
+      method: 'POST',
+      cache: 'no-store',
+      credentials: 'include',
+    });
+  } catch (error) {
+    console.error('Failed to end session:', error);
+  }
+}
+
+export { GetAccessTokenAndUser, GetLists, GetSorts, SortList, WriteSortedList, ClearListCache, SignOut };
@@ -1,13 +1,19 @@
 export interface UserToken {
-  Token: string;
   TokenType: string;
   TokenExpiresIn: number;
-  TokenRefresh: string;
   UserId: string;
   Username: string;
   UserGivenName: string;
 }
 
+// The body of every error response from the backend. The code is stable, so can be used to react to specific failures.
+export interface ErrorResponse {
+  error: {
+    code: string;
+    message: string;
+  };
+}
+
 export interface ListSummary {
   id: string;
   name: string;
@@ -49,27 +55,21 @@ interface ImageInfo {
   };
 }
 
-export const sorts = [
-  { id: 'hue', name: 'Hue' },
-  { id: 'lum', name: 'Luminosity' },
-  { id: 'inverseStep_8', name: 'Inverse Step (8)' },
-  { id: 'inverseStep_12', name: 'Inverse Step (12)' },
-  { id: 'inverseStep2_8', name: 'Inverse Step v2 (8)' },
-  { id: 'inverseStep2_12', name: 'Inverse Step v2 (12)' },
-  { id: 'BRBW1', name: 'BRBW 1' },
-  { id: 'BRBW2', name: 'BRBW 2' },
-] as const;
-
-type SortTypes = (typeof sorts)[number];
-
-type SortIds = SortTypes['id'];
+// A sort method offered by the backend's /api/v1/sorts endpoint, in the order it should be presented
+export interface SortOption {
+  id: string;
+  name: string;
+  description: string;
+  parameters?: Record<string, number>;
+  global: boolean;
+  fixedLayout: boolean; // The sorted order can't be offset or reversed
+}
 
-type SortRanks = {
-  [K in SortIds]: number;
-};
+// Each entry's rank under every sort method, keyed by SortOption id
+type SortRanks = Record<string, number>;
 
 export type SortModeType = {
-  sortMode: SortTypes;
+  sortMode: SortOption;
   visible: boolean;
   reverse: boolean;
 };
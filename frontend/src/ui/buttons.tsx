@@ -1,6 +1,7 @@
 import Cookies from 'js-cookie';
 import { ReactNode, useCallback, useContext } from 'react';
 import { Link, useNavigate } from 'react-router-dom';
+import { SignOut } from '../actions/actions';
 import { ListContext, ListContextType, UserTokenContext, UserTokenContextType } from '../lib/contexts';
 
 const happyButtonStyle =
@@ -36,7 +37,7 @@ const SignOutButton = () => {
 
   const handleSignOut = useCallback(() => {
     navigate('/');
-    Cookies.remove('userToken');
+    SignOut();
     setUserToken(null);
     setList(null);
   }, [navigate, setUserToken, setList]);
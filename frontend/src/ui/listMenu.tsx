@@ -40,7 +40,7 @@ function ListMenu({ setError, loading, setLoading }: Props) {
 
   // Get user lists on mount
   useEffect(() => {
-    GetLists(userToken.Token, userToken.UserId)
+    GetLists()
       .then((ls) => {
         setListSummary(ls);
         setChosenListIndex(0);
@@ -69,7 +69,7 @@ function ListMenu({ setError, loading, setLoading }: Props) {
           return;
         }
         setLoading(true);
-        SortList(userToken.Token, listSummary[chosenListIndex])
+        SortList(listSummary[chosenListIndex])
           .then((lwi) => {
             setList(lwi);
             if (isMobile.matches) {
@@ -91,7 +91,7 @@ function ListMenu({ setError, loading, setLoading }: Props) {
     ClearListCache();
     setList(null);
 
-    GetLists(userToken.Token, userToken.UserId, true)
+    GetLists(true)
       .then((ls) => {
         setListSummary(ls);
         setChosenListIndex(0);
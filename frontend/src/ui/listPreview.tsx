@@ -1,15 +1,18 @@
 import { Dispatch, SetStateAction, useCallback, useContext, useEffect, useState } from 'react';
 import { ListContext, ListContextType, UserTokenContext, UserTokenContextType } from '../lib/contexts';
-import { WriteSortedList } from '../actions/actions';
+import { GetSorts, WriteSortedList } from '../actions/actions';
 import { Button } from './buttons';
 import { ArrowUpIcon, ArrowDownIcon } from '@heroicons/react/16/solid';
-import { SortModeType, sorts } from '../lib/definitions';
+import { SortModeType, SortOption } from '../lib/definitions';
 
 function calcIndex(i: number, startIndex: number, len: number, reverse: boolean) {
   const ind = reverse ? (len - i) % len : i;
   return (ind + startIndex) % len;
 }
 
+// Shown until the sort methods have been loaded from the backend; every sorted list is ranked by hue
+const defaultSort: SortOption = { id: 'hue', name: 'Hue', description: '', global: false, fixedLayout: false };
+
 type Props = {
   readonly setError: Dispatch<SetStateAction<string | null>>;
 };
@@ -17,8 +20,9 @@ type Props = {
 export default function ListPreview({ setError }: Props) {
   const { userToken } = useContext(UserTokenContext) as UserTokenContextType;
   const { list, setList } = useContext(ListContext) as ListContextType;
+  const [sorts, setSorts] = useState<SortOption[]>([defaultSort]);
   const [currSort, setCurrSort] = useState<SortModeType>({
-    sortMode: { id: 'hue', name: 'Hue' },
+    sortMode: defaultSort,
     visible: true,
     reverse: false,
   });
@@ -28,7 +32,7 @@ export default function ListPreview({ setError }: Props) {
   const handleSaveList = useCallback(() => {
     if (userToken && list) {
       setSubmitting(true);
-      WriteSortedList(userToken.Token, list, startIndex, currSort.sortMode.id, currSort.reverse)
+      WriteSortedList(list, startIndex, currSort.sortMode.id, currSort.reverse)
         .then((message) => {
           if (message[0].startsWith('List updated successfully')) {
             setStartIndex(0);
@@ -82,19 +86,29 @@ export default function ListPreview({ setError }: Props) {
         });
       }
     },
-    [list]
+    [list, sorts]
   );
 
+  useEffect(() => {
+    GetSorts()
+      .then((options) => setSorts(options))
+      .catch((error) => {
+        setError(error);
+      });
+  }, [setError]);
+
   // Reset sort/offset on list change
   useEffect(() => {
     setStartIndex(0);
-    setCurrSort({ sortMode: sorts[0], visible: true, reverse: false });
+    setCurrSort({ sortMode: defaultSort, visible: true, reverse: false });
   }, [list]);
 
   return (
     <div className='mx-auto max-w-6xl'>
       <div className='flex flex-wrap justify-between text-sm md:text-base gap-x-4'>
-        <p className='my-auto'>Click a poster to make it the start of the list.</p>
+        <p className='my-auto'>
+          {currSort.sortMode.fixedLayout ? "Grid sorts can't be offset or reversed." : 'Click a poster to make it the start of the list.'}
+        </p>
         <form className='flex justify-end flex-wrap align-middle items-center select-none gap-4 ml-auto'>
           <div className='mx-auto'>
             <input type='checkbox' id='showOriginal' className='hidden peer' checked={!currSort.visible} onChange={handleShowOriginal} />
@@ -119,7 +133,14 @@ export default function ListPreview({ setError }: Props) {
             })}
           </select>
           <div className='mx-auto'>
-            <input type='checkbox' id='reverseOrder' className='hidden peer' checked={currSort.reverse} onChange={handleReverseOrder} />
+            <input
+              type='checkbox'
+              id='reverseOrder'
+              className='hidden peer'
+              checked={currSort.reverse}
+              disabled={currSort.sortMode.fixedLayout}
+              onChange={handleReverseOrder}
+            />
             <label htmlFor='reverseOrder' className='cursor-pointer'>
               {currSort.reverse ? <ArrowUpIcon className='h-4 w-4 md:h-5 md:w-5' /> : <ArrowDownIcon className='h-4 w-4 md:h-5 md:w-5' />}
             </label>
@@ -134,6 +155,7 @@ export default function ListPreview({ setError }: Props) {
             <div key={l.entryId} className='m-1 text-center'>
               <button
                 type='button'
+                disabled={currSort.sortMode.fixedLayout}
                 onClick={() => {
                   setStartIndex(ind);
                 }}>